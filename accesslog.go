@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// accessLogger はリクエストごとのログを出力するロガーです。-access-log を指定しない場合は nil で、slog のデフォルトのロガーに出力します。
+var accessLogger *slog.Logger
+
+// initAccessLog は -log-format の形式でリクエストごとのログをファイルに出力するように設定します。
+// ファイルが maxSize バイトを超えると末尾に .1 を付けた名前に変更し (以前の .1 は上書きします)、新しいファイルに出力を続けます。
+func initAccessLog(filePath, format string, maxSize int64) error {
+	w := &rotatingFile{path: filePath, maxSize: maxSize}
+	if err := w.open(); err != nil {
+		return err
+	}
+	handler, err := newLogHandler(w, format, slog.LevelInfo)
+	if err != nil {
+		return err
+	}
+	accessLogger = slog.New(handler)
+	return nil
+}
+
+// rotatingFile はサイズが上限を超えるとファイルを切り替える io.Writer です。
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64
+}
+
+// open はファイルを追記モードで開きます。
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			// 切り替えられない場合もログを失わないよう、今のファイルに書き続ける
+			fmt.Fprintf(os.Stderr, "failed to rotate access log %s: %v\n", f.path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate は今のファイルを閉じて .1 を付けた名前に変更し、新しいファイルを開きます。
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	renameErr := os.Rename(f.path, f.path+".1")
+	if err := f.open(); err != nil {
+		return err
+	}
+	return renameErr
+}
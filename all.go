@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// allDocument は全ての文書を連結したページの 1 つの文書です。
+type allDocument struct {
+	FileName    string
+	Title       string
+	HTMLContent template.HTML
+}
+
+// handleAll は一覧と同じ順で全ての文書を連結した印刷用のページを表示します。文書ごとに改ページします。
+// 一覧と同様に ?dir= と ?tag= で文書を絞り込めます。
+func handleAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	query := r.URL.Query()
+	indexMutex.RLock()
+	entries := filterByTags(filterByDir(mdEntries, strings.Trim(query.Get("dir"), "/")), query["tag"])
+	indexMutex.RUnlock()
+	var documents []allDocument
+	var mermaid, math bool
+	for _, e := range entries {
+		// 文書ごとにロックを取り、全ての文書を変換する間に走査を待たせないようにする
+		indexMutex.RLock()
+		doc, err := renderDocument(e.FileName, e.ModTime)
+		indexMutex.RUnlock()
+		if err != nil {
+			logRequestError(r, "failed to read %s: %v", e.FileName, err)
+			continue
+		}
+		documents = append(documents, allDocument{FileName: e.FileName, Title: doc.title, HTMLContent: doc.html})
+		mermaid, math = mermaid || doc.mermaid, math || doc.math
+	}
+	data := map[string]any{
+		"Documents":  documents,
+		"SiteTitle":  siteTitle,
+		"CustomCSS":  customCSS != nil,
+		"Msg":        ui,
+		"Mermaid":    mermaid,
+		"MermaidURL": mermaidURL,
+		"Math":       math,
+		"KaTeXURL":   katexBase(""),
+	}
+	if err := allTemplate.Execute(w, data); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// documentJSON は /api/documents/ で返す変換済みの文書です。
+type documentJSON struct {
+	Title   string        `json:"title"`
+	Author  string        `json:"author,omitempty"`
+	Tags    []string      `json:"tags"`
+	Created time.Time     `json:"created"`
+	HTML    template.HTML `json:"html"`
+}
+
+// handleDocumentsAPI は文書の一覧を JSON で返します。並び順は一覧ページと同じです。
+func handleDocumentsAPI(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	indexMutex.RLock()
+	documents := mdEntries
+	indexMutex.RUnlock()
+	if documents == nil {
+		documents = []document{}
+	}
+	writeJSON(w, r, http.StatusOK, documents)
+}
+
+// handleDocumentAPI は /api/documents/<ファイル名>.md で指定した文書を HTML に変換して JSON で返します。
+// HTML 中のリンクは文書のページ (/<ファイル名>.md) からの相対パスです。
+func handleDocumentAPI(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	fileName := strings.TrimPrefix(r.URL.Path, "/api/documents/")
+	info, err := statDocument(fileName)
+	if err != nil || !strings.HasSuffix(strings.ToLower(fileName), ".md") || info.IsDir() {
+		if prefersJSON(r) {
+			writeJSON(w, r, http.StatusNotFound, map[string]string{"error": http.StatusText(http.StatusNotFound)})
+		} else {
+			notFound(w, r)
+		}
+		return
+	}
+	indexMutex.RLock()
+	doc, err := renderDocument(fileName, info.ModTime())
+	indexMutex.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to read %s: %v", mdFilePath(fileName), err)
+		return
+	}
+	tags := doc.matter.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	writeJSON(w, r, http.StatusOK, documentJSON{
+		Title:   doc.title,
+		Author:  doc.matter.Author,
+		Tags:    tags,
+		Created: doc.matter.created(info.ModTime()),
+		HTML:    doc.html,
+	})
+}
+
+// prefersJSON は Accept ヘッダーで HTML より JSON を優先しているかどうかを返します。
+func prefersJSON(r *http.Request) bool {
+	jsonQ, htmlQ := 0.0, 0.0
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, _ := strings.Cut(accept, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if name, value, _ := strings.Cut(strings.TrimSpace(param), "="); name == "q" {
+				if v, err := strconv.ParseFloat(value, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch strings.TrimSpace(mediaType) {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// writeJSON は v を JSON で応答します。
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to encode JSON: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err = w.Write(body); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
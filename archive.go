@@ -0,0 +1,52 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// exportFS はエクスポートした Markdown、画像、ファイルを読み込むファイルシステムです。-zip を指定した場合は ZIP ファイルの中身になります。
+var exportFS fs.FS = osFS{}
+
+// osFS は OS のファイルシステムをそのまま使う fs.FS です。os.DirFS と異なり絶対パスや .. を含むパスも開けます。
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+// openZip は ZIP ファイルを exportFS として開き、-m、-i、-f のディレクトリを ZIP ファイル内のパスに置き換えます。
+func openZip(filePath string) error {
+	archive, err := zip.OpenReader(filePath)
+	if err != nil {
+		return err
+	}
+	exportFS = archive
+	for _, dir := range []*string{&mdDir, &imgDir, &fileDir} {
+		*dir = zipDir(archive, *dir)
+	}
+	return nil
+}
+
+// zipDir は ZIP ファイル内のディレクトリのパスを返します。
+// 直下にない場合は、エクスポートを1つのディレクトリにまとめた ZIP ファイルとみなしてその中から探します。
+func zipDir(fsys fs.FS, dir string) string {
+	dir = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(dir)), "/")
+	if info, err := fs.Stat(fsys, dir); err == nil && info.IsDir() {
+		return dir
+	}
+	entries, _ := fs.ReadDir(fsys, ".")
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if info, err := fs.Stat(fsys, path.Join(entry.Name(), dir)); err == nil && info.IsDir() {
+			return path.Join(entry.Name(), dir)
+		}
+	}
+	return dir
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomarkdown/markdown"
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/html"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+// cachedDoc は HTML に変換した文書です。ファイルの更新日時が変わるまで再利用します。
+type cachedDoc struct {
+	modTime     time.Time
+	title       string
+	matter      frontMatter
+	html        template.HTML
+	toc         []tocEntry
+	readingTime int
+	mermaid     bool
+	math        bool
+}
+
+var (
+	renderCache      = make(map[string]cachedDoc)
+	renderCacheMutex sync.RWMutex
+)
+
+// renderDocument は文書を HTML に変換します。キャッシュした時からファイルが更新されていなければキャッシュを返します。
+// 文書間のリンクの解決に走査したデータを参照するため、indexMutex の読み取りロックを取得して呼び出してください。
+func renderDocument(fileName string, modTime time.Time) (cachedDoc, error) {
+	renderCacheMutex.RLock()
+	cached, ok := renderCache[fileName]
+	renderCacheMutex.RUnlock()
+	if ok && cached.modTime.Equal(modTime) {
+		renderCacheRequests.WithLabelValues("hit").Inc()
+		return cached, nil
+	}
+	renderCacheRequests.WithLabelValues("miss").Inc()
+	start := time.Now()
+	title, matter, content, err := headAndContent(mdFilePath(fileName))
+	if err != nil {
+		return cachedDoc{}, err
+	}
+	doc, rendered := renderContent(content, strings.Repeat("../", strings.Count(fileName, "/")))
+	cached = cachedDoc{
+		modTime:     modTime,
+		title:       title,
+		matter:      matter,
+		html:        template.HTML(rendered),
+		toc:         tableOfContents(doc),
+		readingTime: readingMinutes(nodeText(doc)),
+		mermaid:     hasMermaid(doc),
+		math:        hasMath(doc),
+	}
+	renderDuration.Observe(time.Since(start).Seconds())
+	renderCacheMutex.Lock()
+	renderCache[fileName] = cached
+	renderCacheMutex.Unlock()
+	return cached, nil
+}
+
+// renderContent は文書の本文を DocBase の記法を書き換えてから解析し、構文木と HTML を返します。root は文書からトップへの相対パスです。
+func renderContent(content, root string) (ast.Node, []byte) {
+	mdParser := parser.NewWithExtensions(mathExtensions(parser.CommonExtensions | parser.AutoHeadingIDs | parser.Footnotes))
+	renderer := html.NewRenderer(html.RendererOptions{
+		Flags:                      html.CommonFlags | html.FootnoteReturnLinks,
+		FootnoteReturnLinkContents: "↩",
+		RenderNodeHook:             renderNode,
+	})
+	doc := markdown.Parse(fixContainers(fixMath(fixLinks([]byte(content), root))), mdParser)
+	linkDocuments(doc, root)
+	markEmoji(doc)
+	markExternalLinks(doc)
+	markTaskLists(doc)
+	markContainers(doc)
+	rendered := markdown.Render(doc, renderer)
+	if sanitizePolicy != nil {
+		rendered = sanitizePolicy.SanitizeBytes(rendered)
+	}
+	return doc, rendered
+}
+
+// clearRenderCache はキャッシュを全て破棄します。文書間のリンクのタイトルが変わる場合があるため、文書の一覧を作り直した時に呼び出します。
+func clearRenderCache() {
+	renderCacheMutex.Lock()
+	renderCache = make(map[string]cachedDoc)
+	renderCacheMutex.Unlock()
+}
+
+// renderNode は見出しへのリンクを追加し、コードブロックは標準のレンダラーの代わりに出力します。
+func renderNode(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+	renderHeadingAnchor(w, node, entering)
+	return renderCodeBlock(w, node, entering)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// setupRender は renderContent で文書を変換できるように、構文ハイライトと絵文字の辞書を初期化します。
+func setupRender(tb testing.TB) {
+	tb.Helper()
+	initHighlight("github")
+	setupEmoji(tb)
+}
+
+func TestRenderContentCode(t *testing.T) {
+	setupRender(t)
+	mdNameToPathMap = map[string]string{"5.md": "5.md"}
+	mdNameToTitleMap = map[string]string{"5.md": "設計方針"}
+	t.Cleanup(func() {
+		mdNameToPathMap, mdNameToTitleMap = nil, nil
+	})
+	tests := []struct {
+		name    string
+		input   string
+		want    string
+		notWant string
+	}{
+		{"emoji in code span", "`:memo:`", "<code>:memo:</code>", "📝"},
+		{"link in code span", "`#{5}` and `#5`", "<code>#{5}</code> and <code>#5</code>", "<a "},
+		{"emoji in fence", "```\n:memo: :+1:\n```", ":memo: :+1:", "📝"},
+		{"link in fence", "```\n#{5}\n#5\n```", "#{5}\n", "<a "},
+		{"tilde fence", "~~~\n:memo: #{5}\n~~~", ":memo: #{5}", "<a "},
+		{"outside code", ":memo: #{5} `:memo:`", `📝 🔗 <a href="5.md">設計方針</a> <code>:memo:</code>`, "<code>📝"},
+		{"emoji after code span", "`:smile:` と :smile:", "<code>:smile:</code> と 😄", ""},
+		{"emoji after fence", "```\n:smile:\n```\n:smile:\n", "<p>😄</p>", "😄\n"},
+		{"indented code", "本文\n\n    #{5} :memo:\n", "#{5} :memo:", "&lt;a"},
+		{"longer fence", "````\n```\n:memo: #{5}\n```\n````\n", ":memo: #{5}", "📝"},
+		{"multi-line code span", "`a\n:memo:` b :memo:", "<code>a\n:memo:</code> b 📝", "<code>a\n📝"},
+		{"double backtick span", "`` `:memo:` `` :memo:", "<code>`:memo:`</code> 📝", "<code>`📝"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rendered := renderContent(tt.input, "")
+			got := string(rendered)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("renderContent(%q) = %q, want to contain %q", tt.input, got, tt.want)
+			}
+			if len(tt.notWant) > 0 && strings.Contains(got, tt.notWant) {
+				t.Errorf("renderContent(%q) = %q, want not to contain %q", tt.input, got, tt.notWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// gzipThreshold はこのサイズ未満の応答を圧縮しないしきい値です。
+const gzipThreshold = 1024
+
+// compressibleTypes は圧縮対象とする Content-Type の接頭辞です。画像や PDF などの圧縮済み形式は含めません。
+var compressibleTypes = []string{
+	"text/",
+	"application/javascript",
+	"application/json",
+	"application/rss+xml",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// gzipETagSuffix は圧縮した応答の ETag に付ける接尾辞です。内容が異なる圧縮しない応答と同じ強い ETag にならないようにします。
+const gzipETagSuffix = "-gzip"
+
+// gzipHandler はクライアントが gzip に対応している場合、テキスト系の応答を圧縮するハンドラーを返します。
+// 圧縮した応答の ETag には gzipETagSuffix を付け、条件付きリクエストでは取り除いてからハンドラーに渡します。
+func gzipHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		gw := &gzipWriter{ResponseWriter: w, status: http.StatusOK, head: r.Method == http.MethodHead, gzipETagRequested: stripGzipETags(r.Header)}
+		defer gw.close(r)
+		h.ServeHTTP(gw, r)
+	})
+}
+
+// gzipETag は ETag の引用符の内側の末尾に gzipETagSuffix を付けます。
+func gzipETag(etag string) string {
+	if !strings.HasSuffix(etag, `"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + gzipETagSuffix + `"`
+}
+
+// stripGzipETags は条件付きリクエストのヘッダーの ETag から gzipETagSuffix を取り除き、取り除いたかどうかを返します。
+func stripGzipETags(header http.Header) bool {
+	stripped := false
+	for _, name := range []string{"If-None-Match", "If-Range"} {
+		if value := header.Get(name); strings.Contains(value, gzipETagSuffix+`"`) {
+			header.Set(name, strings.ReplaceAll(value, gzipETagSuffix+`"`, `"`))
+			stripped = true
+		}
+	}
+	return stripped
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		if name, q, _ := strings.Cut(strings.TrimSpace(enc), ";"); strings.TrimSpace(name) == "gzip" {
+			return strings.ReplaceAll(q, " ", "") != "q=0"
+		}
+	}
+	return false
+}
+
+// gzipWriter は応答を gzipThreshold まで溜めてから圧縮するかどうかを決める http.ResponseWriter です。
+type gzipWriter struct {
+	http.ResponseWriter
+	status      int
+	buf         []byte
+	gz          *gzip.Writer
+	passthrough bool
+	wroteHeader bool
+	// head は HEAD のリクエストかどうかです。http.ServeContent は HEAD の場合に本文を書き出さないため、Content-Length で圧縮するかどうかを決めます。
+	head bool
+	// gzipETagRequested は条件付きリクエストが圧縮した応答の ETag を指定していたかどうかです。304 の応答の ETag にも gzipETagSuffix を付けます。
+	gzipETagRequested bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	w.status = status
+	if !w.compressible() {
+		w.passthrough = true
+		w.writeHeader()
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if _, ok := w.Header()["Content-Type"]; !ok && !w.wroteHeader {
+		// net/http と同様に内容から推測しておかないと圧縮対象か判断できない
+		w.Header().Set("Content-Type", http.DetectContentType(p))
+	}
+	switch {
+	case w.gz != nil:
+		return w.gz.Write(p)
+	case w.passthrough:
+		w.writeHeader()
+		return w.ResponseWriter.Write(p)
+	case !w.compressible():
+		w.passthrough = true
+		w.writeHeader()
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < gzipThreshold {
+		return len(p), nil
+	}
+	w.setGzipHeader()
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(p), nil
+}
+
+// setGzipHeader は圧縮した応答のヘッダーを設定します。
+func (w *gzipWriter) setGzipHeader() {
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Del("Content-Length")
+	if etag := w.Header().Get("ETag"); len(etag) > 0 {
+		w.Header().Set("ETag", gzipETag(etag))
+	}
+}
+
+func (w *gzipWriter) compressible() bool {
+	if w.status != http.StatusOK || len(w.Header().Get("Content-Encoding")) > 0 {
+		return false
+	}
+	contentType := w.Header().Get("Content-Type")
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *gzipWriter) writeHeader() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if etag := w.Header().Get("ETag"); w.status == http.StatusNotModified && w.gzipETagRequested && len(etag) > 0 {
+			w.Header().Set("ETag", gzipETag(etag))
+		}
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+// close は溜めていた応答を書き出します。しきい値に満たない応答は圧縮せずに書き出します。
+// 本文のない HEAD の応答は、GET の場合と同じヘッダーになるように Content-Length がしきい値以上なら圧縮した応答のヘッダーにします。
+func (w *gzipWriter) close(r *http.Request) {
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			logRequestError(r, "failed to write compressed response: %v", err)
+		}
+		return
+	}
+	if w.head && !w.passthrough && len(w.buf) == 0 && w.compressible() {
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && n >= gzipThreshold {
+			w.setGzipHeader()
+		}
+	}
+	w.writeHeader()
+	if len(w.buf) > 0 {
+		if _, err := w.ResponseWriter.Write(w.buf); err != nil {
+			logRequestError(r, "failed to write response: %v", err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGzipHandlerETag(t *testing.T) {
+	content := strings.Repeat("docbaseview ", gzipThreshold)
+	handler := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", `"abc"`)
+		http.ServeContent(w, r, "doc.txt", time.Time{}, bytes.NewReader([]byte(content)))
+	}))
+	tests := []struct {
+		name        string
+		gzip        bool
+		ifNoneMatch string
+		status      int
+		etag        string
+		encoding    string
+	}{
+		{name: "identity", status: http.StatusOK, etag: `"abc"`},
+		{name: "gzip", gzip: true, status: http.StatusOK, etag: `"abc-gzip"`, encoding: "gzip"},
+		{name: "gzip not modified", gzip: true, ifNoneMatch: `"abc-gzip"`, status: http.StatusNotModified, etag: `"abc-gzip"`},
+		{name: "identity not modified", ifNoneMatch: `"abc"`, status: http.StatusNotModified, etag: `"abc"`},
+		{name: "identity tag with gzip", gzip: true, ifNoneMatch: `"abc"`, status: http.StatusNotModified, etag: `"abc"`},
+		{name: "gzip tag without gzip", ifNoneMatch: `"abc-gzip"`, status: http.StatusOK, etag: `"abc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/doc.txt", nil)
+			if tt.gzip {
+				r.Header.Set("Accept-Encoding", "gzip")
+			}
+			if len(tt.ifNoneMatch) > 0 {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+			if etag := w.Header().Get("ETag"); etag != tt.etag {
+				t.Errorf("ETag = %s, want %s", etag, tt.etag)
+			}
+			if encoding := w.Header().Get("Content-Encoding"); encoding != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, tt.encoding)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("Vary = %q, want Accept-Encoding", vary)
+			}
+		})
+	}
+}
+
+func TestGzipHandlerHead(t *testing.T) {
+	handler := gzipHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("ETag", `"abc"`)
+		content := "small"
+		if r.URL.Path == "/large.txt" {
+			content = strings.Repeat("docbaseview ", gzipThreshold)
+		}
+		http.ServeContent(w, r, "doc.txt", time.Time{}, strings.NewReader(content))
+	}))
+	for _, target := range []string{"/large.txt", "/small.txt"} {
+		responses := make(map[string]*httptest.ResponseRecorder)
+		for _, method := range []string{http.MethodGet, http.MethodHead} {
+			r := httptest.NewRequest(method, target, nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			responses[method] = w
+		}
+		get, head := responses[http.MethodGet].Header(), responses[http.MethodHead].Header()
+		for _, name := range []string{"Content-Encoding", "ETag", "Vary"} {
+			if get.Get(name) != head.Get(name) {
+				t.Errorf("%s of %s: HEAD %q, GET %q", name, target, head.Get(name), get.Get(name))
+			}
+		}
+		if responses[http.MethodHead].Body.Len() > 0 {
+			t.Errorf("HEAD wrote a body of %d bytes", responses[http.MethodHead].Body.Len())
+		}
+	}
+}
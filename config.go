@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix は全てのフラグの代わりに指定できる環境変数の接頭辞です。
+const envPrefix = "DOCBASEVIEW_"
+
+// legacyEnvNames は接頭辞の付いた環境変数がない場合に使う、接頭辞なしの環境変数の名前です。
+var legacyEnvNames = map[string]string{
+	"p":    "PORT",
+	"host": "HOST",
+}
+
+// shortFlagEnvNames は短い名前のフラグに対応する環境変数の名前 (接頭辞を除く) です。
+var shortFlagEnvNames = map[string]string{
+	"p":       "PORT",
+	"m":       "MD_DIR",
+	"i":       "IMG_DIR",
+	"f":       "FILE_DIR",
+	"bu":      "BASIC_USER",
+	"bp":      "BASIC_PASSWORD",
+	"bp-file": "BASIC_PASSWORD_FILE",
+}
+
+// envName はフラグに対応する環境変数の名前を返します。短い名前のフラグ以外は、名前を大文字にして - を _ に置き換えます
+// (-sort は DOCBASEVIEW_SORT、-metrics-token は DOCBASEVIEW_METRICS_TOKEN)。
+func envName(flagName string) string {
+	if name, ok := shortFlagEnvNames[flagName]; ok {
+		return envPrefix + name
+	}
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// commandLineFlags はコマンドラインで指定したフラグの名前です。
+// mergeOptions は設定ファイルと環境変数の値も flag.Set で反映するため、flag.Visit ではコマンドラインで指定したかどうかを判定できません。
+var commandLineFlags = make(map[string]bool)
+
+// mergeOptions は設定ファイルと環境変数の値をフラグに反映します。
+// 優先順位はコマンドラインのフラグ、環境変数、設定ファイル、デフォルト値の順です。
+// 設定ファイルはフラグを省略した場合、環境変数 DOCBASEVIEW_CONFIG で指定することもできます。
+func mergeOptions(configFile string) error {
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		commandLineFlags[f.Name] = true
+	})
+	if len(configFile) == 0 {
+		configFile = os.Getenv(envName("config"))
+	}
+	if len(configFile) > 0 {
+		if err := loadConfig(configFile, explicit); err != nil {
+			return err
+		}
+	}
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" || err != nil {
+			return
+		}
+		key := envName(f.Name)
+		value := os.Getenv(key)
+		if legacy, ok := legacyEnvNames[f.Name]; ok && len(value) == 0 {
+			key, value = legacy, os.Getenv(legacy)
+		}
+		if len(value) > 0 {
+			if e := flag.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value of %s: %w", key, e)
+			}
+		}
+	})
+	return err
+}
+
+// loadConfig は YAML の設定ファイルを読み込み、explicit に含まれないフラグに値を設定します。
+// 設定ファイルのキーはフラグの名前 (p、m、auth、sort など) です。
+func loadConfig(filePath string, explicit map[string]bool) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	var values map[string]any
+	if err = yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", filePath, err)
+	}
+	for name, value := range values {
+		if name == "config" || flag.Lookup(name) == nil {
+			return fmt.Errorf("unknown option in %s: %s", filePath, name)
+		}
+		if explicit[name] {
+			continue
+		}
+		s := ""
+		if value != nil {
+			s = fmt.Sprint(value)
+		}
+		if err = flag.Set(name, s); err != nil {
+			return fmt.Errorf("invalid value of %s in %s: %w", name, filePath, err)
+		}
+	}
+	return nil
+}
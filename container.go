@@ -0,0 +1,85 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// containerPattern は :::details タイトル や :::warning のようにブロックを開始する行です。
+var containerPattern = regexp.MustCompile(`^:::\s*(details|info|warning|note|tip)(\s+.*)?$`)
+
+// fixContainers は :::details タイトル などの開始の行と ::: の行をそれぞれ独立した段落になるように空行で囲みます。
+// 閉じられていないブロックは文書の末尾で閉じます。段落は markContainers で HTML の要素に置き換えます。
+func fixContainers(input []byte) []byte {
+	depth := 0
+	s := replaceLinesOutsideCodeBlocks(string(input), func(line string) string {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case containerPattern.MatchString(trimmed):
+			depth++
+		case trimmed == ":::" && depth > 0:
+			depth--
+		default:
+			return line
+		}
+		// 前の段落の定義リストとして解釈されないように、先頭の : をエスケープする
+		return "\n\\" + trimmed + "\n\n"
+	})
+	if depth > 0 {
+		s += strings.Repeat("\n\n\\:::\n", depth)
+	}
+	return []byte(s)
+}
+
+// markContainers は :::details タイトル の段落を details 要素と summary 要素の開始に、
+// :::info、:::warning、:::note、:::tip の段落を callout クラスの div 要素の開始に、::: の段落をそれぞれの要素の終了に置き換えます。
+// 間の Markdown は通常どおり変換されるため、見出しは目次にも含まれます。
+func markContainers(doc ast.Node) {
+	var paragraphs []*ast.Paragraph
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if para, ok := node.(*ast.Paragraph); ok && entering {
+			paragraphs = append(paragraphs, para)
+			return ast.SkipChildren
+		}
+		return ast.GoToNext
+	})
+	var closing []string
+	for _, para := range paragraphs {
+		text := strings.TrimSpace(nodeText(para))
+		switch m := containerPattern.FindStringSubmatch(text); {
+		case m != nil && m[1] == "details":
+			title := strings.TrimSpace(m[2])
+			if len(title) == 0 {
+				title = "Details"
+			}
+			closing = append(closing, "</details>")
+			replaceNode(para, "<details><summary>"+template.HTMLEscapeString(title)+"</summary>")
+		case m != nil:
+			html := `<div class="callout callout-` + m[1] + `">`
+			if title := strings.TrimSpace(m[2]); len(title) > 0 {
+				html += `<p class="callout-title">` + template.HTMLEscapeString(title) + "</p>"
+			}
+			closing = append(closing, "</div>")
+			replaceNode(para, html)
+		case text == ":::" && len(closing) > 0:
+			replaceNode(para, closing[len(closing)-1])
+			closing = closing[:len(closing)-1]
+		}
+	}
+}
+
+// replaceNode はノードを HTML のブロックに置き換えます。
+func replaceNode(node ast.Node, html string) {
+	parent := node.GetParent()
+	block := &ast.HTMLBlock{Leaf: ast.Leaf{Literal: []byte(html)}}
+	block.SetParent(parent)
+	children := parent.GetChildren()
+	for i, child := range children {
+		if child == node {
+			children[i] = block
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// コードブロックにコピーボタンを付ける。ボタンの文言は body 要素の data-copy と data-copied 属性で指定する。Clipboard API が使えない環境 (HTTP での閲覧など) ではボタンを付けない。
+(function () {
+    if (!navigator.clipboard) {
+        return;
+    }
+    document.addEventListener("DOMContentLoaded", function () {
+        const copyLabel = document.body.dataset.copy || "Copy";
+        const copiedLabel = document.body.dataset.copied || "Copied!";
+        document.querySelectorAll("pre:not(.mermaid)").forEach(function (pre) {
+            const button = document.createElement("button");
+            button.type = "button";
+            button.className = "copy";
+            button.textContent = copyLabel;
+            button.addEventListener("click", function () {
+                const text = (pre.querySelector("code") || pre).innerText;
+                navigator.clipboard.writeText(text).then(function () {
+                    button.textContent = copiedLabel;
+                    setTimeout(function () {
+                        button.textContent = copyLabel;
+                    }, 1500);
+                }, function () {
+                    button.textContent = copyLabel;
+                });
+            });
+            pre.appendChild(button);
+        });
+    });
+})();
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// setupEmoji は組み込みの gemoji の辞書を emojiTable に読み込みます。
+func setupEmoji(tb testing.TB) {
+	tb.Helper()
+	emojiTable = nil
+	if err := json.Unmarshal(gemojiJSON, &emojiTable); err != nil {
+		tb.Fatalf("failed to parse embedded emoji table: %v", err)
+	}
+}
+
+func TestReplaceEmoji(t *testing.T) {
+	setupEmoji(t)
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"single", "今日は:smile:です", "今日は😄です"},
+		{"plus one", ":+1:", "👍"},
+		{"adjacent same", ":+1::+1:", "👍👍"},
+		{"adjacent different", ":smile::+1:", "😄👍"},
+		{"three in a row", ":smile::smile::smile:", "😄😄😄"},
+		{"in parentheses", "(:tada:)", "(🎉)"},
+		{"unknown shortcode", ":no_such_emoji:", ":no_such_emoji:"},
+		{"unknown before known", ":no_such_emoji::smile:", ":no_such_emoji::smile:"},
+		{"time", "12:30:45", "12:30:45"},
+		{"inside word", "foo:smile:bar", "foo:smile:bar"},
+		{"longer token", ":a:smile:", ":a:smile:"},
+		{"url path", "http://example.com/:smile:", "http://example.com/:smile:"},
+		{"url port", "http://localhost:8080:smile:", "http://localhost:8080:smile:"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceEmoji(tt.input); got != tt.want {
+				t.Errorf("replaceEmoji(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+// emojiBenchmarkDocument はベンチマーク用の、絵文字と時刻や URL、コードブロックを含む文書です。
+var emojiBenchmarkDocument = []byte(strings.Repeat("## 議事録 :memo:\n\n"+
+	"- 12:30 開始 :+1::+1: http://localhost:8080/docs を確認 :tada:\n"+
+	"- 未知のショートコード :no_such_emoji: はそのまま\n\n"+
+	"```go\nfmt.Println(\":smile:\")\n```\n\n", 200))
+
+func BenchmarkReplaceEmoji(b *testing.B) {
+	setupEmoji(b)
+	s := string(emojiBenchmarkDocument)
+	b.SetBytes(int64(len(s)))
+	for i := 0; i < b.N; i++ {
+		replaceEmoji(s)
+	}
+}
+
+// BenchmarkReplaceEmojiReplaceAll は比較のため、辞書の全てのショートコードを strings.ReplaceAll で置き換える以前の方法を計測します。
+func BenchmarkReplaceEmojiReplaceAll(b *testing.B) {
+	setupEmoji(b)
+	b.SetBytes(int64(len(emojiBenchmarkDocument)))
+	for i := 0; i < b.N; i++ {
+		s := string(emojiBenchmarkDocument)
+		for name, emoji := range emojiTable {
+			s = strings.ReplaceAll(s, ":"+name+":", emoji)
+		}
+	}
+}
+
+func BenchmarkRenderContent(b *testing.B) {
+	setupRender(b)
+	content := string(emojiBenchmarkDocument)
+	b.SetBytes(int64(len(content)))
+	for i := 0; i < b.N; i++ {
+		renderContent(content, "")
+	}
+}
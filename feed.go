@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedLimit はフィードに含める文書の数です。
+var feedLimit int
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+// handleFeed は更新日時の新しい順に feedLimit 件の文書を RSS 2.0 のフィードで返します。
+func handleFeed(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	indexMutex.RLock()
+	documents := make([]document, len(mdEntries))
+	copy(documents, mdEntries)
+	indexMutex.RUnlock()
+	// 一覧の -mtime は front matter の作成日時で並べるため、フィードは更新日時で別に並べます。
+	sort.SliceStable(documents, func(i, j int) bool { return documents[i].ModTime.After(documents[j].ModTime) })
+	if len(documents) > feedLimit {
+		documents = documents[:feedLimit]
+	}
+	base := requestBaseURL(r)
+	feed := rss{Version: "2.0", Channel: rssChannel{
+		Title:       siteTitle,
+		Link:        base + "/",
+		Description: "Recently updated documents",
+	}}
+	for _, doc := range documents {
+		link := base + documentPath(doc.FileName)
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:   doc.Title,
+			Link:    link,
+			GUID:    link,
+			PubDate: doc.ModTime.Format(time.RFC1123Z),
+		})
+	}
+	body, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to encode feed: %v", err)
+		return
+	}
+	write(w, r, append([]byte(xml.Header), body...), "application/rss+xml; charset=utf-8")
+}
+
+// baseURL はフィード、サイトマップ、OpenSearch description で使う URL の先頭部分です。空の場合はリクエストのホストから作ります。
+var baseURL string
+
+// requestBaseURL は baseURL、または指定がなければリクエストのホストからスキームとホストまでの URL を作ります。
+func requestBaseURL(r *http.Request) string {
+	if len(baseURL) > 0 {
+		return strings.TrimSuffix(baseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// documentPath は文書のページの URL のパスをエスケープして返します。
+func documentPath(fileName string) string {
+	return (&url.URL{Path: "/" + fileName}).EscapedPath()
+}
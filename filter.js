@@ -0,0 +1,20 @@
+// 一覧の絞り込み。入力した文字列をファイル名かタイトルに含む文書だけを表示する (大文字と小文字は区別しない)。
+// サーバーには問い合わせず、表示中のページの文書だけを対象にする。
+(function () {
+    document.addEventListener("DOMContentLoaded", function () {
+        const input = document.getElementById("filter");
+        if (!input) {
+            return;
+        }
+        input.addEventListener("input", function () {
+            const query = input.value.toLowerCase();
+            document.querySelectorAll("ul.documents > li").forEach(function (li) {
+                li.hidden = !li.textContent.toLowerCase().includes(query);
+            });
+            // 全ての文書が隠れたグループは見出しも隠す
+            document.querySelectorAll("details.group").forEach(function (group) {
+                group.hidden = !group.querySelector("ul.documents > li:not([hidden])");
+            });
+        });
+    });
+})();
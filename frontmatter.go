@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterDelimiter は front matter の開始と終了を表す行です。
+const frontMatterDelimiter = "---"
+
+// frontMatter は文書の先頭にある YAML の front matter です。
+type frontMatter struct {
+	Title   string    `yaml:"title"`
+	Author  string    `yaml:"author"`
+	Tags    tagList   `yaml:"tags"`
+	Created time.Time `yaml:"created"`
+	Date    time.Time `yaml:"date"`
+}
+
+// created は front matter の created、なければ date の作成日時を返します。どちらもない場合は modTime を返します。
+func (m frontMatter) created(modTime time.Time) time.Time {
+	switch {
+	case !m.Created.IsZero():
+		return m.Created
+	case !m.Date.IsZero():
+		return m.Date
+	}
+	return modTime
+}
+
+// tagList はリスト形式とカンマ区切りの文字列形式のどちらでも書けるタグの一覧です。
+type tagList []string
+
+func (t *tagList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		for _, tag := range strings.Split(value.Value, ",") {
+			if tag = strings.TrimSpace(tag); len(tag) > 0 {
+				*t = append(*t, tag)
+			}
+		}
+		return nil
+	}
+	var tags []string
+	if err := value.Decode(&tags); err != nil {
+		return err
+	}
+	*t = tags
+	return nil
+}
+
+// scanHead は文書の先頭から front matter とタイトルを読み取ります。
+// front matter に title がない場合は titleAfterFrontMatter で本文からタイトルを探します。
+// front matter が閉じられていない場合や YAML として不正な場合は先頭行をタイトルとします。
+// consumed はタイトルと front matter として読み取った、本文に含めない先頭からの行数です。
+func scanHead(scanner *bufio.Scanner) (head string, matter frontMatter, consumed int) {
+	if !scanner.Scan() {
+		return
+	}
+	head, consumed = scanner.Text(), 1
+	if head != frontMatterDelimiter {
+		head = headingTitle(head)
+		return
+	}
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if lines[len(lines)-1] != frontMatterDelimiter {
+			continue
+		}
+		if err := yaml.Unmarshal([]byte(strings.Join(lines[:len(lines)-1], "\n")), &matter); err != nil {
+			matter = frontMatter{}
+			break
+		}
+		head, consumed = matter.Title, 1+len(lines)
+		if len(head) == 0 {
+			head, consumed = titleAfterFrontMatter(scanner, consumed)
+		}
+		return
+	}
+	return
+}
+
+// titleAfterFrontMatter は title のない front matter に続く本文からタイトルを探し、タイトルと本文に含めない行数を返します。
+// 空行を読み飛ばし、最初の行が見出しの場合はその見出しをタイトルとして本文から除きます。
+// 最初の行が見出しでない場合は本文を残したまま、コードブロックの外の最初の見出しをタイトルとします。
+// 見出しがない場合は、これまでと同じように最初の行をタイトルとして本文から除きます。
+func titleAfterFrontMatter(scanner *bufio.Scanner, consumed int) (string, int) {
+	first, started, fence := "", false, ""
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !started && len(trimmed) == 0:
+			consumed++
+			continue
+		case len(fence) > 0:
+			if strings.HasPrefix(trimmed, fence) {
+				fence = ""
+			}
+		case strings.HasPrefix(trimmed, "```"):
+			fence = "```"
+		case strings.HasPrefix(trimmed, "~~~"):
+			fence = "~~~"
+		case headingPattern.MatchString(trimmed):
+			if !started {
+				return headingTitle(line), consumed + 1
+			}
+			return headingTitle(line), consumed
+		case !started:
+			first = line
+		}
+		started = true
+	}
+	if len(first) == 0 {
+		return "", consumed
+	}
+	return first, consumed + 1
+}
+
+// skipLines は先頭から n 行を除いたデータを返します。改行は変換しません。
+func skipLines(data []byte, n int) []byte {
+	for i := 0; i < n; i++ {
+		j := bytes.IndexByte(data, '\n')
+		if j < 0 {
+			return nil
+		}
+		data = data[j+1:]
+	}
+	return data
+}
+
+// headingTitle はタイトルの行が「# 設計方針」のような Markdown の見出しの場合に、# と前後の空白を取り除きます。
+// 「# 設計方針 #」のような閉じの # も取り除きますが、「# C#」のように空白を挟まない # は残します。見出しでない行はそのまま返します。
+func headingTitle(line string) string {
+	trimmed := strings.TrimSpace(line)
+	if !headingPattern.MatchString(trimmed) {
+		return line
+	}
+	title := strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+	if closed := strings.TrimRight(title, "#"); len(closed) == 0 || strings.HasSuffix(closed, " ") {
+		title = strings.TrimSpace(closed)
+	}
+	return title
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanHead(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		head     string
+		consumed int
+	}{
+		{"heading", "# 設計方針\n本文\n", "設計方針", 1},
+		{"front matter title", "---\ntitle: 設計方針\n---\n# 見出し\n", "設計方針", 3},
+		{"next line", "---\ntags: [a]\n---\n# 設計方針\n本文\n", "設計方針", 4},
+		{"blank lines", "---\ntags: [a]\n---\n\n\n# 設計方針\n本文\n", "設計方針", 6},
+		{"later heading", "---\ntags: [a]\n---\n\n本文\n\n## 設計方針\n", "設計方針", 4},
+		{"heading in code", "---\ntags: [a]\n---\n```sh\n# comment\n```\n# 設計方針\n", "設計方針", 3},
+		{"no heading", "---\ntags: [a]\n---\n\n設計方針\n本文\n", "設計方針", 5},
+		{"empty body", "---\ntags: [a]\n---\n\n", "", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			head, _, consumed := scanHead(newLineScanner(strings.NewReader(tt.input)))
+			if head != tt.head || consumed != tt.consumed {
+				t.Errorf("scanHead(%q) = %q, %d, want %q, %d", tt.input, head, consumed, tt.head, tt.consumed)
+			}
+		})
+	}
+}
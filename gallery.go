@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// galleryPageSize はギャラリーの 1 ページあたりの画像の数です。
+const galleryPageSize = 100
+
+// galleryImage はギャラリーに表示する画像です。
+type galleryImage struct {
+	Link string
+	Doc  *document
+}
+
+// imageReferences は全ての文書の本文から画像のリンクを探し、画像を参照している文書の対応を作ります。
+// 複数の文書から参照されている画像は一覧で先に現れる文書を対応させます。画像のリンクは imgLinkToNameMap と同様に linkKey で正規化します。
+func imageReferences(index []searchEntry) map[string]document {
+	refs := make(map[string]document)
+	for _, e := range index {
+		for _, m := range imgLinkPattern.FindAllStringSubmatch(string(e.body), -1) {
+			if link := linkKey(m[1]); len(refs[link].FileName) == 0 {
+				refs[link] = e.doc
+			}
+		}
+	}
+	return refs
+}
+
+// handleGallery は全ての画像の縮小画像を一覧で表示します。
+func handleGallery(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	indexMutex.RLock()
+	links := make([]string, 0, len(imgLinkToNameMap))
+	for link := range imgLinkToNameMap {
+		links = append(links, link)
+	}
+	sort.Strings(links)
+	pages := (len(links) + galleryPageSize - 1) / galleryPageSize
+	var images []galleryImage
+	for i := (page - 1) * galleryPageSize; i < len(links) && i < page*galleryPageSize; i++ {
+		image := galleryImage{Link: links[i]}
+		if doc, ok := imgLinkToDocMap[links[i]]; ok {
+			image.Doc = &doc
+		}
+		images = append(images, image)
+	}
+	indexMutex.RUnlock()
+	data := map[string]any{"Images": images, "Page": page, "Pages": pages, "CustomCSS": customCSS != nil, "Msg": ui}
+	if page > 1 {
+		data["Prev"] = page - 1
+	}
+	if page < pages {
+		data["Next"] = page + 1
+	}
+	if err := galleryTemplate.Execute(w, data); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
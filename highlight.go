@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/gomarkdown/markdown/ast"
+)
+
+var (
+	highlightFormatter = chromahtml.New(chromahtml.WithClasses(true))
+	highlightStyle     *chroma.Style
+	highlightCSS       []byte
+	mermaidURL         string
+)
+
+// initHighlight は指定したテーマでシンタックスハイライトのスタイルシートを生成します。
+func initHighlight(theme string) {
+	if _, ok := styles.Registry[theme]; !ok {
+		log.Printf("WARNING: unknown highlight theme %s, using %s", theme, styles.Fallback.Name)
+	}
+	highlightStyle = styles.Get(theme)
+	var buf bytes.Buffer
+	if err := highlightFormatter.WriteCSS(&buf, highlightStyle); err != nil {
+		log.Printf("failed to generate highlight stylesheet: %v", err)
+	}
+	highlightCSS = buf.Bytes()
+}
+
+// renderCodeBlock は言語が指定されたフェンスコードブロックをハイライトして出力します。
+// 言語の指定がない場合や未知の言語の場合は標準のレンダラーに任せます。
+func renderCodeBlock(w io.Writer, node ast.Node, _ bool) (ast.WalkStatus, bool) {
+	block, ok := node.(*ast.CodeBlock)
+	if !ok {
+		return ast.GoToNext, false
+	}
+	lang := codeLang(block)
+	if len(lang) == 0 {
+		return ast.GoToNext, false
+	}
+	if lang == "mermaid" && len(mermaidURL) > 0 {
+		// mermaid.js が要素の中身をテキストとして読み取って図に置き換える
+		io.WriteString(w, `<pre class="mermaid">`+html.EscapeString(string(block.Literal))+"</pre>\n")
+		return ast.GoToNext, true
+	}
+	lexer := lexers.Get(lang)
+	if lexer == nil {
+		return ast.GoToNext, false
+	}
+	iterator, err := chroma.Coalesce(lexer).Tokenise(nil, string(block.Literal))
+	if err != nil {
+		log.Printf("failed to tokenize %s code block: %v", lang, err)
+		return ast.GoToNext, false
+	}
+	if err = highlightFormatter.Format(w, highlightStyle, iterator); err != nil {
+		log.Printf("failed to highlight %s code block: %v", lang, err)
+	}
+	return ast.GoToNext, true
+}
+
+// codeLang はフェンスコードブロックに指定された言語を返します。
+func codeLang(block *ast.CodeBlock) string {
+	info := bytes.Fields(block.Info)
+	if len(info) == 0 {
+		return ""
+	}
+	// DocBase では ```go:main.go のようにファイル名を付けられる
+	lang, _, _ := strings.Cut(string(info[0]), ":")
+	return lang
+}
+
+// hasMermaid は文書に Mermaid の図が含まれるかどうかを返します。
+func hasMermaid(doc ast.Node) bool {
+	if len(mermaidURL) == 0 {
+		return false
+	}
+	found := false
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if block, ok := node.(*ast.CodeBlock); ok && codeLang(block) == "mermaid" {
+			found = true
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	return found
+}
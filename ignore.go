@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// ignoreFileName は一覧や直接のアクセスから隠す文書のパターンを書くファイルの名前です。Markdown のディレクトリに置きます。
+const ignoreFileName = ".docbaseviewignore"
+
+// ignorePatterns は ignoreFileName から読み込んだパターンです。indexMutex で保護します。
+var ignorePatterns []string
+
+// readIgnorePatterns は ignoreFileName を読み込み、空行と # で始まる行を除いたパターンを返します。ファイルがない場合は nil を返します。
+func readIgnorePatterns() ([]string, error) {
+	content, err := fs.ReadFile(exportFS, mdFilePath(ignoreFileName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var patterns []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored は Markdown のディレクトリからの相対パス fileName が patterns のいずれかに一致するかどうかを返します。
+// ignoreFileName 自体も常に隠します。
+func ignored(patterns []string, fileName string) bool {
+	if fileName == ignoreFileName {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matchIgnorePattern(pattern, fileName) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIgnorePattern は .gitignore と同じようにパターンを照合します。
+// / を含まないパターンはどの階層のファイル名やディレクトリ名にも一致し、/ を含むパターンは Markdown のディレクトリからの相対パスと照合します。
+// 末尾が / のパターンはディレクトリだけに一致し、その中のファイルを全て隠します。
+func matchIgnorePattern(pattern, fileName string) bool {
+	dirOnly := strings.HasSuffix(pattern, "/")
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	segments := strings.Split(fileName, "/")
+	for i := range segments {
+		if dirOnly && i == len(segments)-1 {
+			break
+		}
+		target := segments[i]
+		if anchored {
+			target = strings.Join(segments[:i+1], "/")
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// statDocument は文書の情報を返します。ignoreFileName で隠した文書と、followSymlinks でない場合のシンボリックリンクの文書は fs.ErrNotExist を返します。
+func statDocument(fileName string) (fs.FileInfo, error) {
+	indexMutex.RLock()
+	hidden := ignored(ignorePatterns, strings.TrimPrefix(path.Clean("/"+fileName), "/"))
+	indexMutex.RUnlock()
+	if hidden || throughSymlink(exportFS, mdDir, fileName) {
+		return nil, fs.ErrNotExist
+	}
+	return fs.Stat(exportFS, mdFilePath(fileName))
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// brokenLink はリンク先の文書が存在しない文書間のリンクです。
+type brokenLink struct {
+	Source document
+	Target string
+}
+
+var brokenLinks []brokenLink
+
+// checkLinks は全ての文書の本文から #{123} 形式のリンクを探し、リンク先が存在しないものを返します。
+// fixLinks と同様に、コードブロックとインラインコードの中は対象にしません。
+func checkLinks(index []searchEntry, nameToPath map[string]string) []brokenLink {
+	var broken []brokenLink
+	for _, e := range index {
+		replaceOutsideCode(string(e.body), func(s string) string {
+			for _, m := range mdLinkPattern.FindAllStringSubmatch(s, -1) {
+				if len(m[1]) == 0 {
+					continue // #123 は番号付きの見出しやアンカーの場合がある
+				}
+				target := m[1] + ".md"
+				if _, ok := nameToPath[target]; !ok {
+					broken = append(broken, brokenLink{Source: e.doc, Target: target})
+					log.Printf("WARNING: broken link from %s to %s", e.doc.FileName, target)
+				}
+			}
+			return s
+		})
+	}
+	return broken
+}
+
+// collectBacklinks は全ての文書の本文から #{123} 形式のリンクを探し、リンク先の文書のパスからリンク元の文書への逆引きを作ります。
+// 同じ文書からの複数のリンクは 1 つにまとめ、自分自身へのリンクは含めません。
+func collectBacklinks(index []searchEntry, nameToPath map[string]string) map[string][]document {
+	backlinks := make(map[string][]document)
+	for _, e := range index {
+		linked := make(map[string]bool)
+		replaceOutsideCode(string(e.body), func(s string) string {
+			for _, m := range mdLinkPattern.FindAllStringSubmatch(s, -1) {
+				if len(m[1]) == 0 {
+					continue
+				}
+				target, ok := nameToPath[m[1]+".md"]
+				if ok && target != e.doc.FileName && !linked[target] {
+					linked[target] = true
+					backlinks[target] = append(backlinks[target], e.doc)
+				}
+			}
+			return s
+		})
+	}
+	return backlinks
+}
+
+func handleBrokenLinks(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	indexMutex.RLock()
+	links := brokenLinks
+	indexMutex.RUnlock()
+	if err := brokenLinksTemplate.Execute(w, map[string]any{"Links": links, "CustomCSS": customCSS != nil, "Msg": ui}); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
+
+// externalNewTab は外部へのリンクを新しいタブで開くかどうかです。
+var externalNewTab bool
+
+// linkDocuments は本文のテキストの #{123} と #123 を文書へのリンクに置き換えます。
+// 解析した後のテキストのノードだけを書き換えるため、コード、HTML のタグや属性値の中はそのまま残ります。
+// リンクテキストの中はリンクが入れ子にならないように書き換えず、見出しの中の #123 は番号付きの見出しとみなして #{123} だけを書き換えます。
+func linkDocuments(doc ast.Node, root string) {
+	replaceTextNodes(doc, func(text *ast.Text) []ast.Node {
+		if insideNode(text, isLinkNode) {
+			return nil
+		}
+		heading := insideNode(text, isHeadingNode)
+		literal := string(text.Literal)
+		var nodes []ast.Node
+		last := 0
+		for _, m := range mdLinkPattern.FindAllStringSubmatchIndex(literal, -1) {
+			var name string
+			start := m[0]
+			switch {
+			case m[2] >= 0:
+				name = literal[m[2]:m[3]]
+			case heading:
+				continue
+			default:
+				name, start = literal[m[6]:m[7]], m[5]
+			}
+			nodes = append(nodes,
+				&ast.Text{Leaf: ast.Leaf{Literal: []byte(literal[last:start])}},
+				&ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(mdLink(name+".md", root))}})
+			last = m[1]
+		}
+		if nodes == nil {
+			return nil
+		}
+		return append(nodes, &ast.Text{Leaf: ast.Leaf{Literal: []byte(literal[last:])}})
+	})
+}
+
+// replaceTextNodes は文書のテキストのノードごとに f を呼び出し、f が nil 以外を返した場合はそのノードで置き換えます。
+// 空のテキストのノードは追加しません。
+func replaceTextNodes(doc ast.Node, f func(text *ast.Text) []ast.Node) {
+	var texts []*ast.Text
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if text, ok := node.(*ast.Text); ok && entering {
+			texts = append(texts, text)
+		}
+		return ast.GoToNext
+	})
+	for _, text := range texts {
+		nodes := f(text)
+		if nodes == nil {
+			continue
+		}
+		parent := text.GetParent()
+		var children []ast.Node
+		for _, child := range parent.GetChildren() {
+			if child != ast.Node(text) {
+				children = append(children, child)
+				continue
+			}
+			for _, node := range nodes {
+				if t, ok := node.(*ast.Text); ok && len(t.Literal) == 0 {
+					continue
+				}
+				node.SetParent(parent)
+				children = append(children, node)
+			}
+		}
+		parent.SetChildren(children)
+	}
+}
+
+// insideNode はノードの祖先に match に一致するノードがあるかどうかを返します。
+func insideNode(node ast.Node, match func(ast.Node) bool) bool {
+	for parent := node.GetParent(); parent != nil; parent = parent.GetParent() {
+		if match(parent) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLinkNode(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.Link, *ast.Image:
+		return true
+	}
+	return false
+}
+
+func isHeadingNode(node ast.Node) bool {
+	_, ok := node.(*ast.Heading)
+	return ok
+}
+
+// markExternalLinks は外部へのリンクを新しいタブで開くように target と rel の属性を追加します。
+// 文書間のリンクなどの相対リンクと、localhost へのリンクはそのままにします。
+func markExternalLinks(doc ast.Node) {
+	if !externalNewTab {
+		return
+	}
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		if link, ok := node.(*ast.Link); ok && entering && isExternalLink(string(link.Destination)) {
+			link.AdditionalAttributes = append(link.AdditionalAttributes, `target="_blank"`, `rel="noopener noreferrer"`)
+		}
+		return ast.GoToNext
+	})
+}
+
+func isExternalLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return false
+	}
+	return true
+}
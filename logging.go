@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+)
+
+// requestIDPattern は受け付ける X-Request-ID ヘッダーの値です。ログを壊すような値はリクエスト ID を作り直します。
+var requestIDPattern = regexp.MustCompile(`^[0-9A-Za-z._-]{1,64}$`)
+
+type requestIDKey struct{}
+
+// initLogger は -log-format で指定した形式 (text または json) で、-log-level で指定したレベル以上のログを出力するように設定します。
+// log パッケージで出力するログも同じ形式になります。
+func initLogger(format, level string) error {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level: %s", level)
+	}
+	handler, err := newLogHandler(os.Stderr, format, minLevel)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// newLogHandler は w に format の形式 (text または json) で level 以上のログを出力する slog.Handler を作ります。
+func newLogHandler(w io.Writer, format string, level slog.Leveler) (slog.Handler, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "text":
+		return slog.NewTextHandler(w, opts), nil
+	case "json":
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+}
+
+// logHandler はリクエストごとにクライアントの IP アドレス、メソッド、パス、ステータスコード、応答のサイズ、処理時間を 1 行のログに出力するハンドラーを返します。
+// リクエスト ID は X-Request-ID ヘッダーがあればその値を、なければ新しく作って応答のヘッダーとログに含めます。
+func logHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDPattern.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+		observeRequest(r, sw.status, time.Since(start))
+		logger := accessLogger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Info("request",
+			"request_id", id,
+			"client_ip", clientIP(r),
+			"method", r.Method,
+			"path", r.URL.RequestURI(),
+			"status", sw.status,
+			"bytes", sw.bytes,
+			"latency", time.Since(start),
+		)
+	})
+}
+
+// newRequestID は 8 バイトの乱数を 16 進数にしたリクエスト ID を作ります。
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "-"
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID は logHandler でリクエストに付けたリクエスト ID を返します。
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// logRequestError はリクエストの処理中に起きたエラーを、パスとリクエスト ID 付きでログに出力します。
+func logRequestError(r *http.Request, format string, args ...any) {
+	slog.Error(fmt.Sprintf(format, args...), "request_id", requestID(r), "path", r.URL.RequestURI())
+}
+
+// statusWriter は応答したステータスコードとサイズを記録する http.ResponseWriter です。
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += n
+	return n, err
+}
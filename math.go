@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+	"github.com/gomarkdown/markdown/parser"
+)
+
+var (
+	katexURL string
+	katexDir string
+)
+
+// initKaTeX は -katex の指定を確認し、ディレクトリが指定された場合はその中身を /katex/ で配信します。
+func initKaTeX(location string) {
+	if len(location) == 0 {
+		return
+	}
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		katexDir = location
+		files := http.StripPrefix("/katex/", http.FileServer(http.Dir(katexDir)))
+		http.HandleFunc("/katex/", func(w http.ResponseWriter, r *http.Request) {
+			if authorized(w, r) {
+				files.ServeHTTP(w, r)
+			}
+		})
+		return
+	}
+	katexURL = strings.TrimSuffix(location, "/") + "/"
+}
+
+// katexBase は文書から見た KaTeX の配布ファイルの URL を返します。
+func katexBase(root string) string {
+	if len(katexDir) > 0 {
+		return root + "katex/"
+	}
+	return katexURL
+}
+
+// mathExtensions は数式の描画が有効な場合に限り、パーサーの数式の拡張を有効にしたフラグを返します。
+func mathExtensions(extensions parser.Extensions) parser.Extensions {
+	if len(katexURL) == 0 && len(katexDir) == 0 {
+		return extensions &^ parser.MathJax
+	}
+	return extensions | parser.MathJax
+}
+
+// fixMath は数式の区切りとみなさない $ を文字参照に置き換えます。
+// $5 のような金額が数式として扱われないように、Pandoc と同様に開始の $ の直後と終了の $ の直前が空白でなく、
+// 終了の $ の直後が数字でない場合のみ数式とみなします。$$ はブロックの数式として残します。
+func fixMath(input []byte) []byte {
+	if len(katexURL) == 0 && len(katexDir) == 0 {
+		return input
+	}
+	return []byte(replaceOutsideCode(string(input), func(s string) string {
+		if !strings.Contains(s, "$") {
+			return s
+		}
+		var sb strings.Builder
+		for i := 0; i < len(s); i++ {
+			switch {
+			case s[i] != '$':
+				sb.WriteByte(s[i])
+			case strings.HasPrefix(s[i:], "$$"):
+				sb.WriteString("$$")
+				i++
+			default:
+				if end := closingDollar(s, i); end > 0 {
+					sb.WriteString(s[i : end+1])
+					i = end
+				} else {
+					sb.WriteString("&#36;")
+				}
+			}
+		}
+		return sb.String()
+	}))
+}
+
+// closingDollar は start の $ で始まるインライン数式を閉じる $ の位置を返します。見つからない場合は -1 を返します。
+func closingDollar(s string, start int) int {
+	if start+1 >= len(s) || parser.IsSpace(s[start+1]) || s[start+1] == '$' {
+		return -1
+	}
+	for i := start + 2; i < len(s); i++ {
+		if s[i] != '$' {
+			continue
+		}
+		if parser.IsSpace(s[i-1]) || (i+1 < len(s) && (s[i+1] == '$' || '0' <= s[i+1] && s[i+1] <= '9')) {
+			return -1
+		}
+		return i
+	}
+	return -1
+}
+
+// hasMath は文書に数式が含まれるかどうかを返します。
+func hasMath(doc ast.Node) bool {
+	found := false
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		switch node.(type) {
+		case *ast.Math, *ast.MathBlock:
+			found = true
+			return ast.Terminate
+		}
+		return ast.GoToNext
+	})
+	return found
+}
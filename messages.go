@@ -0,0 +1,115 @@
+package main
+
+// uiMessages はページに表示する文言です。% を含むものはテンプレートで printf の書式として使います。
+type uiMessages struct {
+	Lang            string // html 要素の lang 属性
+	Documents       string
+	Filter          string
+	Folder          string
+	ShowAll         string
+	Search          string
+	SearchTitle     string
+	Sort            string
+	SortTitle       string
+	SortName        string
+	SortNewest      string
+	SortOldest      string
+	Uncategorized   string
+	Previous        string
+	Next            string
+	DocumentCount   string
+	DocumentsFound  string
+	Home            string
+	ReadingTime     string
+	ReferencedBy    string
+	Related         string
+	BrokenLinks     string
+	NoBrokenLinks   string
+	Gallery         string
+	NoImages        string
+	NotFound        string
+	IsNotFound      string
+	BackToDocuments string
+	AllDocuments    string
+	NoDocuments     string
+	Download        string
+	Copy            string
+	Copied          string
+}
+
+// uiMessagesByLang は -lang で指定できる言語ごとの文言です。
+var uiMessagesByLang = map[string]*uiMessages{
+	"en": {
+		Lang:            "en",
+		Documents:       "Documents",
+		Filter:          "Filter this page",
+		Folder:          "Folder: %s",
+		ShowAll:         "show all",
+		Search:          "Search",
+		SearchTitle:     "Search: %s",
+		Sort:            "Sort:",
+		SortTitle:       "title",
+		SortName:        "name",
+		SortNewest:      "newest",
+		SortOldest:      "oldest",
+		Uncategorized:   "Uncategorized",
+		Previous:        "Previous",
+		Next:            "Next",
+		DocumentCount:   "%d documents",
+		DocumentsFound:  "%d documents found.",
+		Home:            "Home",
+		ReadingTime:     "~%d min read",
+		ReferencedBy:    "Referenced by",
+		Related:         "Related",
+		BrokenLinks:     "Broken Links",
+		NoBrokenLinks:   "No broken links found.",
+		Gallery:         "Gallery",
+		NoImages:        "No images found.",
+		NotFound:        "Not Found",
+		IsNotFound:      "is not found.",
+		BackToDocuments: "Back to documents",
+		AllDocuments:    "All documents",
+		NoDocuments:     "No documents found.",
+		Download:        "Download",
+		Copy:            "Copy",
+		Copied:          "Copied!",
+	},
+	"ja": {
+		Lang:            "ja",
+		Documents:       "文書一覧",
+		Filter:          "このページを絞り込み",
+		Folder:          "フォルダ: %s",
+		ShowAll:         "全て表示",
+		Search:          "検索",
+		SearchTitle:     "検索: %s",
+		Sort:            "並び順:",
+		SortTitle:       "タイトル",
+		SortName:        "ファイル名",
+		SortNewest:      "新しい順",
+		SortOldest:      "古い順",
+		Uncategorized:   "未分類",
+		Previous:        "前へ",
+		Next:            "次へ",
+		DocumentCount:   "%d 件",
+		DocumentsFound:  "%d 件の文書が見つかりました。",
+		Home:            "ホーム",
+		ReadingTime:     "約 %d 分で読めます",
+		ReferencedBy:    "この文書へのリンク",
+		Related:         "関連する文書",
+		BrokenLinks:     "リンク切れ",
+		NoBrokenLinks:   "リンク切れはありません。",
+		Gallery:         "画像一覧",
+		NoImages:        "画像はありません。",
+		NotFound:        "見つかりません",
+		IsNotFound:      "は見つかりません。",
+		BackToDocuments: "文書一覧に戻る",
+		AllDocuments:    "全ての文書",
+		NoDocuments:     "文書はありません。",
+		Download:        "ダウンロード",
+		Copy:            "コピー",
+		Copied:          "コピーしました",
+	},
+}
+
+// ui は -lang で選んだ言語の文言です。
+var ui = uiMessagesByLang["en"]
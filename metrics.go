@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "docbaseview_http_requests_total",
+		Help: "Number of the HTTP requests by the class of the path and the status code.",
+	}, []string{"class", "status"})
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "docbaseview_http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests by the class of the path.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"class"})
+	renderDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "docbaseview_render_duration_seconds",
+		Help:    "Time to render a Markdown document to HTML, excluding the cache hits.",
+		Buckets: prometheus.DefBuckets,
+	})
+	renderCacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "docbaseview_render_cache_requests_total",
+		Help: "Number of the lookups of the render cache by the result (hit or miss).",
+	}, []string{"result"})
+)
+
+// metricsToken は /metrics へのアクセスに必要な Bearer トークンです。空の場合はトークンなしで取得できます。
+var metricsToken string
+
+// registerMetrics は Prometheus のメトリクスを登録し、/metrics で公開します。
+// スクレイプしやすいように Basic 認証はかけず、-metrics-token を指定した場合は Bearer トークンで保護します。
+func registerMetrics() {
+	prometheus.MustRegister(requestsTotal, requestDuration, renderDuration, renderCacheRequests)
+	metrics := promhttp.Handler()
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		if len(metricsToken) > 0 {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(metricsToken)) != 1 {
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
+
+// observeRequest はリクエストの結果をメトリクスに記録します。
+func observeRequest(r *http.Request, status int, latency time.Duration) {
+	class := pathClass(r)
+	requestsTotal.WithLabelValues(class, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(class).Observe(latency.Seconds())
+}
+
+// pathClass はメトリクスのラベルの値が増えすぎないように、パスを index、markdown、image、file、api、other に分類します。
+// catchAll で処理するパスは catchAll と同じ基準で分類します。
+func pathClass(r *http.Request) string {
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "/" {
+		if strings.HasPrefix(pattern, "/api/") {
+			return "api"
+		}
+		return "other"
+	}
+	fileName := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/"))
+	switch {
+	case len(fileName) == 0, fileName == "index.html":
+		return "index"
+	case strings.HasSuffix(fileName, ".md"), strings.HasSuffix(fileName, ".md.txt"):
+		return "markdown"
+	}
+	switch path.Ext(fileName) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".svg", ".webp":
+		return "image"
+	}
+	return "file"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+)
+
+type openSearchDescription struct {
+	XMLName       xml.Name      `xml:"http://a9.com/-/spec/opensearch/1.1/ OpenSearchDescription"`
+	ShortName     string        `xml:"ShortName"`
+	Description   string        `xml:"Description"`
+	InputEncoding string        `xml:"InputEncoding"`
+	URL           openSearchURL `xml:"Url"`
+}
+
+type openSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+// handleOpenSearch はブラウザの検索エンジンとして全文検索を追加するための OpenSearch description を返します。
+// 検索結果ではなく検索の URL だけを含むため、ブラウザが認証なしで取得できるように Basic 認証はかけません。
+func handleOpenSearch(w http.ResponseWriter, r *http.Request) {
+	description := openSearchDescription{
+		ShortName:     siteTitle,
+		Description:   "Search " + siteTitle,
+		InputEncoding: "UTF-8",
+		URL: openSearchURL{
+			Type:     "text/html",
+			Template: requestBaseURL(r) + "/search?q={searchTerms}",
+		},
+	}
+	body, err := xml.MarshalIndent(description, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to encode OpenSearch description: %v", err)
+		return
+	}
+	write(w, r, append([]byte(xml.Header), body...), "application/opensearchdescription+xml; charset=utf-8")
+}
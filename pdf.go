@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pdfTimeout は 1 つの文書を PDF に変換するコマンドのタイムアウトです。
+const pdfTimeout = time.Minute
+
+// pdfCommand は文書の HTML を標準入力から読み込み、PDF を標準出力に書き出すコマンドです (wkhtmltopdf --quiet - - など)。
+// 空の場合は PDF での出力を無効にします。
+var pdfCommand string
+
+// pdfBaseURL は変換するコマンドが画像やスタイルシートを読み込むこのサーバーの URL です。
+// リクエストの Host ヘッダーは偽装できるため使わず、-base-url を指定していなければ待ち受けるアドレスにします。
+var pdfBaseURL string
+
+// listenURL はこのホストから待ち受けるアドレスに接続する URL を返します。全てのインターフェースで待ち受ける場合は localhost に接続します。
+func listenURL(host string, port int, https bool) string {
+	switch host {
+	case "", "0.0.0.0", "::":
+		host = "localhost"
+	}
+	scheme := "http"
+	if https {
+		scheme = "https"
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// handlePDF は /pdf/<ファイル名>.md で指定した文書を、文書のページと同じ HTML から -pdf-command で PDF に変換して応答します。
+func handlePDF(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	fileName := strings.TrimPrefix(r.URL.Path, "/pdf/")
+	info, err := statDocument(fileName)
+	if len(pdfCommand) == 0 || err != nil || !strings.HasSuffix(strings.ToLower(fileName), ".md") || info.IsDir() {
+		notFound(w, r)
+		return
+	}
+	data, err := documentPageData(fileName, info.ModTime())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to read %s: %v", mdFilePath(fileName), err)
+		return
+	}
+	// 変換するコマンドが画像やスタイルシートを読み込めるように、文書のページの URL を基準にする。
+	// Basic 認証がかかっている場合は、リクエストと同じ資格情報を URL に含める。
+	// 資格情報を他のホストに送らないように、URL はリクエストの Host ヘッダーではなく pdfBaseURL から作る
+	base, err := url.Parse(pdfBaseURL + documentPath(fileName))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to build base URL of %s: %v", fileName, err)
+		return
+	}
+	if user, password, ok := r.BasicAuth(); ok {
+		base.User = url.UserPassword(user, password)
+	}
+	data["Base"], data["PDF"], data["Shortcuts"] = base.String(), false, false
+	var html bytes.Buffer
+	if err = documentTemplate.Execute(&html, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to render %s: %v", fileName, err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), pdfTimeout)
+	defer cancel()
+	args := strings.Fields(pdfCommand)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	var pdf, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &html, &pdf, &stderr
+	if err = cmd.Run(); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		logRequestError(r, "failed to convert %s to PDF: %v: %s", fileName, err, strings.TrimSpace(stderr.String()))
+		return
+	}
+	name, _ := data["Title"].(string)
+	if len(strings.TrimSpace(name)) == 0 {
+		name = strings.TrimSuffix(path.Base(fileName), path.Ext(fileName))
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".pdf"}))
+	write(w, r, pdf.Bytes(), "application/pdf")
+}
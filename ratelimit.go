@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitCleanupInterval は使われなくなったクライアントのバケットを削除する間隔です。
+const rateLimitCleanupInterval = time.Minute
+
+// trustProxy は X-Forwarded-For ヘッダーをクライアントの IP アドレスとして信頼するかどうかです。
+var trustProxy bool
+
+// rateLimiter はクライアントの IP アドレスごとのトークンバケットでリクエストを制限します。
+type rateLimiter struct {
+	rate    float64 // 1 秒あたりに補充するトークンの数
+	burst   float64 // バケットに溜められるトークンの数
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// newRateLimiter は 1 秒あたり rate 回、一度に最大 burst 回までのリクエストを許可する rateLimiter を作ります。
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	l := &rateLimiter{rate: rate, burst: math.Max(float64(burst), 1), buckets: make(map[string]*tokenBucket)}
+	go func() {
+		for range time.Tick(rateLimitCleanupInterval) {
+			l.cleanup()
+		}
+	}()
+	return l
+}
+
+// allow はクライアント key のリクエストを許可するかどうかを返します。許可しない場合は次に許可するまでの時間も返します。
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup はトークンが満タンまで補充されたバケットを削除します。削除しても次のリクエストで同じ状態から始まります。
+func (l *rateLimiter) cleanup() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitHandler はクライアントの IP アドレスごとにリクエストを制限し、超えた場合は 429 と Retry-After ヘッダーを応答するハンドラーを返します。
+// l が nil の場合は制限しません。死活監視の /healthz は制限の対象外です。
+func rateLimitHandler(l *rateLimiter, h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			if ok, wait := l.allow(clientIP(r)); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP はリクエストしたクライアントの IP アドレスを返します。
+// -trust-proxy を指定した場合は、X-Forwarded-For ヘッダーの最後のアドレス (直前のリバースプロキシが追加したもの)、
+// なければ X-Real-IP ヘッダーのアドレスを使います。どちらもない場合や IP アドレスとして正しくない場合は接続元のアドレスを使います。
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addrs := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := net.ParseIP(strings.TrimSpace(addrs[len(addrs)-1])); ip != nil {
+				return ip.String()
+			}
+		}
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// cjkCharsPerMinute は 1 分間に読める日本語などの文字数です。
+	cjkCharsPerMinute = 400
+	// wordsPerMinute は 1 分間に読める英語などの単語数です。
+	wordsPerMinute = 200
+)
+
+// readingMinutes は本文を読むのにかかるおおよその分数を返します。
+// 日本語などの CJK の文字は文字数で、それ以外は単語数で数えます。
+func readingMinutes(text string) int {
+	cjk := 0
+	words := strings.FieldsFunc(text, func(c rune) bool {
+		if isCJK(c) {
+			cjk++
+			return true
+		}
+		return unicode.IsSpace(c) || unicode.IsPunct(c)
+	})
+	minutes := math.Ceil(float64(cjk)/cjkCharsPerMinute + float64(len(words))/wordsPerMinute)
+	return int(math.Max(minutes, 1))
+}
+
+func isCJK(c rune) bool {
+	return unicode.In(c, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
@@ -0,0 +1,21 @@
+// 数式と Mermaid の図を描画する。ライブラリは文書に数式や図がある場合だけ読み込まれる。
+// Content-Security-Policy でインラインのスクリプトを禁止しても動くように、テンプレートに直接書かずにこのファイルから呼び出す。
+(function () {
+    document.addEventListener("DOMContentLoaded", function () {
+        if (typeof renderMathInElement === "function") {
+            // $ で囲んだ数式はサーバー側で \( \) と \[ \] に変換済みのため、それ以外の区切りは使わない
+            renderMathInElement(document.body, {
+                delimiters: [
+                    {left: "\\(", right: "\\)", display: false},
+                    {left: "\\[", right: "\\]", display: true}
+                ]
+            });
+        }
+        if (typeof mermaid !== "undefined") {
+            const dark = document.documentElement.dataset.theme === "dark" ||
+                (!document.documentElement.dataset.theme && matchMedia("(prefers-color-scheme: dark)").matches);
+            mermaid.initialize({startOnLoad: false, theme: dark ? "dark" : "default"});
+            mermaid.run();
+        }
+    });
+})();
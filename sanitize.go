@@ -0,0 +1,26 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// sanitizePolicy は変換した HTML を無害化するポリシーです。nil の場合は無害化しません。
+var sanitizePolicy *bluemonday.Policy
+
+// initSanitize は bluemonday の UGC ポリシーに、文書の変換で出力する要素と属性を追加したポリシーを作ります。
+func initSanitize() {
+	p := bluemonday.UGCPolicy()
+	// 構文ハイライト、数式、Mermaid の図は class で見た目を決めている
+	p.AllowAttrs("class").Globally()
+	// 日本語の見出しから作った ID も目次のリンク先として残す。UGC ポリシーが許可する ASCII だけの ID にも
+	// 一致させると id 属性が重複して出力されるため、それ以外の文字を含む ID だけを追加で許可する
+	p.AllowAttrs("id").Matching(regexp.MustCompile(`[^a-zA-Z0-9:\-_.]`)).Globally()
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("disabled", "checked").OnElements("input")
+	p.AllowAttrs("target").Matching(regexp.MustCompile(`^_blank$`)).OnElements("a")
+	// rel は bluemonday が作り直すため、markExternalLinks で付けた noreferrer を付け直す
+	p.RequireNoReferrerOnFullyQualifiedLinks(externalNewTab)
+	sanitizePolicy = p
+}
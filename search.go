@@ -0,0 +1,170 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// snippetLength はスニペットの最大文字数です。
+const snippetLength = 160
+
+// searchEntry は全文検索のためにメモリ上に保持する文書です。
+type searchEntry struct {
+	doc          document
+	body         []rune
+	lowerTitle   []rune
+	lowerContent []rune
+}
+
+type searchResult struct {
+	FileName string
+	Title    string
+	Snippet  template.HTML
+}
+
+var searchIndex []searchEntry
+
+// buildSearchIndex は文書の本文を読み込んで検索用のインデックスを作ります。
+func buildSearchIndex(docs []document) []searchEntry {
+	index := make([]searchEntry, 0, len(docs))
+	for _, doc := range docs {
+		filePath := mdFilePath(doc.FileName)
+		_, _, content, err := headAndContent(filePath)
+		if err != nil {
+			log.Printf("failed to read %s for search index: %v", filePath, err)
+			continue
+		}
+		body := []rune(content)
+		index = append(index, searchEntry{
+			doc:          doc,
+			body:         body,
+			lowerTitle:   toLowerRunes([]rune(doc.Title)),
+			lowerContent: toLowerRunes(body),
+		})
+	}
+	return index
+}
+
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	query := r.URL.Query().Get("q")
+	var terms [][]rune
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, toLowerRunes([]rune(term)))
+	}
+	var results []searchResult
+	if len(terms) > 0 {
+		indexMutex.RLock()
+		results = search(terms)
+		indexMutex.RUnlock()
+	}
+	if err := searchTemplate.Execute(w, map[string]any{"Query": query, "Results": results, "CustomCSS": customCSS != nil, "Msg": ui}); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
+
+// search は全ての語を含む (AND 検索) 文書を返します。
+func search(terms [][]rune) []searchResult {
+	var results []searchResult
+	for _, e := range searchIndex {
+		first := -1
+		matched := true
+		for i, term := range terms {
+			pos := indexRunes(e.lowerContent, term)
+			if pos < 0 && indexRunes(e.lowerTitle, term) < 0 {
+				matched = false
+				break
+			}
+			if i == 0 || first < 0 {
+				first = pos
+			}
+		}
+		if !matched {
+			continue
+		}
+		results = append(results, searchResult{
+			FileName: e.doc.FileName,
+			Title:    e.doc.Title,
+			Snippet:  snippet(e.body, e.lowerContent, first, terms),
+		})
+	}
+	return results
+}
+
+// snippet は pos の周辺を最大 snippetLength 文字切り出し、一致した部分を <mark> で囲みます。
+func snippet(body, lower []rune, pos int, terms [][]rune) template.HTML {
+	start := 0
+	if pos > snippetLength/4 {
+		start = pos - snippetLength/4
+	}
+	end := start + snippetLength
+	if end > len(body) {
+		end = len(body)
+	}
+	var sb strings.Builder
+	if start > 0 {
+		sb.WriteString("…")
+	}
+	plain := start
+	for i := start; i < end; {
+		n := 0
+		for _, term := range terms {
+			if len(term) > n && i+len(term) <= end && equalRunes(lower[i:i+len(term)], term) {
+				n = len(term)
+			}
+		}
+		if n == 0 {
+			i++
+			continue
+		}
+		sb.WriteString(template.HTMLEscapeString(flatten(body[plain:i])))
+		sb.WriteString("<mark>" + template.HTMLEscapeString(flatten(body[i:i+n])) + "</mark>")
+		i += n
+		plain = i
+	}
+	sb.WriteString(template.HTMLEscapeString(flatten(body[plain:end])))
+	if end < len(body) {
+		sb.WriteString("…")
+	}
+	return template.HTML(sb.String())
+}
+
+var whitespaceReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\t", " ")
+
+func flatten(s []rune) string {
+	return whitespaceReplacer.Replace(string(s))
+}
+
+func toLowerRunes(s []rune) []rune {
+	lower := make([]rune, len(s))
+	for i, c := range s {
+		lower[i] = unicode.ToLower(c)
+	}
+	return lower
+}
+
+func indexRunes(s, sub []rune) int {
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if equalRunes(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func equalRunes(a, b []rune) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
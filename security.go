@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// contentSecurityPolicy は応答に付ける Content-Security-Policy ヘッダーの値です。
+var contentSecurityPolicy string
+
+// initCSP は -csp で指定したポリシーを使うように設定します。空の場合は defaultCSP で作ったポリシーを使います。
+func initCSP(policy string) {
+	if len(policy) == 0 {
+		policy = defaultCSP()
+	}
+	contentSecurityPolicy = policy
+}
+
+// defaultCSP は -mermaid と -katex で指定した配信元からのスクリプトなどだけを許可するポリシーを返します。
+// 数式や図の描画結果と文書中の HTML の style 属性のためにインラインのスタイルは許可し、画像は外部のものも許可します。
+func defaultCSP() string {
+	scripts := []string{"'self'"}
+	styles := []string{"'self'", "'unsafe-inline'"}
+	fonts := []string{"'self'", "data:"}
+	if origin := urlOrigin(mermaidURL); len(origin) > 0 {
+		scripts = append(scripts, origin)
+	}
+	if origin := urlOrigin(katexURL); len(origin) > 0 {
+		if origin != urlOrigin(mermaidURL) {
+			scripts = append(scripts, origin)
+		}
+		styles = append(styles, origin)
+		fonts = append(fonts, origin)
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src " + strings.Join(scripts, " "),
+		"style-src " + strings.Join(styles, " "),
+		"font-src " + strings.Join(fonts, " "),
+		"img-src * data:",
+		"frame-ancestors 'self'",
+	}, "; ")
+}
+
+// urlOrigin は URL のスキームとホストを返します。絶対 URL でない場合は空文字列を返します。
+func urlOrigin(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// securityHandler は全ての応答にブラウザの保護機能を有効にするヘッダーを付けるハンドラーを返します。
+func securityHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "SAMEORIGIN")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Content-Security-Policy", contentSecurityPolicy)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -8,35 +8,195 @@ Usage:
 The flags are:
 
 	-p
-		リッスンする TCP ポートを指定します。デフォルトは 8080 です。環境変数 PORT がある場合はそちらを優先します。
+		リッスンする TCP ポートを指定します。デフォルトは 8080 です。環境変数 DOCBASEVIEW_PORT または PORT でも指定できます。
+	-host
+		リッスンするアドレスを指定します (127.0.0.1 など)。デフォルトは空で、全てのインターフェースで待ち受けます。環境変数 DOCBASEVIEW_HOST または HOST でも指定できます。
+	-read-timeout
+		リクエストの読み込みのタイムアウト (30s など) を指定します。デフォルトは 30s で、0 にするとタイムアウトしません。
+	-write-timeout
+		応答の書き込みのタイムアウトを指定します。大きなファイルのダウンロードが途中で切れないよう、デフォルトは 10m です。0 にするとタイムアウトしません。
+	-idle-timeout
+		Keep-Alive の接続で次のリクエストを待つタイムアウトを指定します。デフォルトは 2m で、0 にするとタイムアウトしません。
+	-config
+		フラグの値を YAML の設定ファイルで指定します。キーはフラグの名前 (p、m、auth、sort など) です。
+		値の優先順位はコマンドラインのフラグ、環境変数、設定ファイル、デフォルト値の順です。
 	-m
 		エクスポートした Markdown ファイルのディレクトリを指定します。デフォルトは md です。
 	-i
 		エクスポートした画像ファイルのディレクトリを指定します。デフォルトは img です。
 	-f
 		エクスポートしたその他ファイルのディレクトリを指定します。デフォルトは file です。
+	-zip
+		DocBase からダウンロードしたエクスポートの ZIP ファイルを展開せずにそのまま閲覧します。
+		-m、-i、-f は ZIP ファイル内のディレクトリとして扱い、直下にない場合は1階層下のディレクトリから探します。-watch とは同時に指定できません。
+	-follow-symlinks
+		Markdown、画像、ファイルのディレクトリ内のシンボリックリンクをたどり、リンク先のディレクトリも走査します。
+		デフォルトは true です。-follow-symlinks=false とするとシンボリックリンクを無視します。
+	-watch
+		Markdown、画像、ファイルのディレクトリを監視し、ファイルが追加・削除・変更された時に一覧などを作り直します。
+	-cert
+		HTTPS で待ち受けるための証明書ファイルを指定します。-key と同時に指定する必要があります。
+		HTTPS でもポートは -p または環境変数 PORT で指定します (デフォルトの 8080 のままなので、443 で待ち受ける場合は明示してください)。
+	-key
+		HTTPS で待ち受けるための秘密鍵ファイルを指定します。-cert と同時に指定する必要があります。
 	-bu
 		Basic 認証のユーザー名を指定します。省略すると Basic 認証を無効にします。
 	-bp
-		Basic 認証のパスワードを指定します。
+		Basic 認証のパスワードを指定します。コマンドライン引数は同じホストの他のユーザーから ps で見えてしまうため、
+		-bp-file または環境変数 DOCBASEVIEW_BASIC_PASSWORD で指定することを推奨します。
+	-bp-file
+		Basic 認証のパスワードを書いたファイルを指定します。末尾の改行は取り除きます。
+		指定すると -bp と環境変数 DOCBASEVIEW_BASIC_PASSWORD より優先します。
+	-auth
+		Basic 認証のユーザーを htpasswd 形式 (ユーザー名:bcrypt ハッシュ) のファイルで指定します。指定すると -bu と -bp は無視されます。
+	-auth-realm
+		Basic 認証の realm (ブラウザのログインのダイアログに表示されることがある文字列) を指定します。デフォルトは「ログインしてください」です。
+	-lang
+		ページに表示する文言の言語を en (英語) または ja (日本語) で指定します。デフォルトは en です。
+	-title
+		ヘッダーとページのタイトルに表示するサイト名を指定します。デフォルトは DocBase Viewer です。
+	-logo
+		ヘッダーにサイト名と並べて表示するロゴ画像の URL を指定します。省略するとロゴは表示しません。
+	-shortcuts
+		キーボードショートカット (/ で絞り込みまたは検索、g i で一覧へ移動、一覧の j と k で文書を選択) を有効にします。
+		デフォルトは true です。-shortcuts=false とすると無効にします。
+	-sort
+		一覧の並び順を title (タイトル順)、name (ファイル名順)、mtime (更新日時の古い順)、-mtime (更新日時の新しい順) のいずれかで指定します。
+		front matter に created または date で作成日時がある文書は、更新日時の代わりに作成日時で並べます。
+		デフォルトは title です。一覧ページの sort クエリで一時的に変更することもできます。
+	-theme
+		ソースコードの構文ハイライトのスタイル (chroma のスタイル名) を指定します。デフォルトは github です。
+	-mermaid
+		Mermaid の図を描画するための mermaid.js の URL を指定します。デフォルトは jsDelivr の CDN (mermaid 10 系) です。
+		空にすると Mermaid の図を描画せず、通常のコードブロックとして表示します。
+	-katex
+		数式を描画するための KaTeX の配布ファイル (katex.min.js などを含む dist ディレクトリ) の URL を指定します。デフォルトは jsDelivr の CDN です。
+		ローカルのディレクトリを指定すると /katex/ で配信するため、オフラインでも数式を描画できます。空にすると数式を描画しません。
+	-external-new-tab
+		外部のサイトへのリンクを新しいタブで開きます (target="_blank" と rel="noopener noreferrer" を付けます)。デフォルトは true です。
+		-external-new-tab=false とすると同じタブで開きます。
+	-sanitize
+		文書に含まれる生の HTML を無害化します (script 要素やイベントハンドラーの属性などを取り除きます)。デフォルトは false です。
+		複数の作成者の文書を含むエクスポートを公開する場合は有効にしてください。
+	-csp
+		応答の Content-Security-Policy ヘッダーを指定します。省略すると自身と -mermaid、-katex の配信元からのスクリプトだけを許可し、
+		インラインのスクリプトを禁止するポリシーを使います。X-Content-Type-Options、X-Frame-Options、Referrer-Policy は常に付けます。
+	-feed-limit
+		/feed.xml で配信する RSS フィードに含める文書の数を指定します。デフォルトは 20 です。
+	-base-url
+		/feed.xml、/sitemap.xml、/opensearch.xml で使う URL の先頭部分 (https://docs.example.com など) を指定します。
+		省略するとリクエストの Host ヘッダーから作ります。リバースプロキシの配下で公開する場合は指定してください。
+	-webp
+		WebP に対応したブラウザには JPEG と PNG の画像を WebP に変換して応答します。デフォルトは true です。
+		変換には cgo (libwebp) が必要なため、CGO_ENABLED=0 でビルドした場合は常に無効になります。-webp=false とすると変換しません。
+	-rate
+		クライアントの IP アドレスごとに 1 秒あたりに受け付けるリクエストの数を指定します (0.5 なども指定できます)。
+		超えた場合は 429 Too Many Requests と Retry-After ヘッダーを応答します。デフォルトは 0 で、制限しません。/healthz は制限しません。
+	-rate-burst
+		-rate を指定した場合に、クライアントの IP アドレスごとに一度に受け付けるリクエストの数を指定します。
+		画像の多い文書を開くと一度に多くのリクエストがあるため、デフォルトは 50 です。
+	-trust-proxy
+		X-Forwarded-For ヘッダーの最後のアドレス (直前のリバースプロキシが追加したもの)、なければ X-Real-IP ヘッダーのアドレスを
+		クライアントの IP アドレスとしてログと -rate の制限に使います。ヘッダーは偽装できるため、リバースプロキシの配下で動かす場合だけ指定してください。デフォルトは false です。
+	-log-format
+		ログの形式を text (key=value 形式) または json で指定します。デフォルトは text です。
+		リクエストごとにクライアントの IP アドレス、メソッド、パス、ステータスコード、応答のサイズ、処理時間を 1 行で出力します。
+	-log-level
+		出力するログの最低のレベルを debug、info、warn、error のいずれかで指定します。デフォルトは info です。
+		debug にすると、画像やファイルのリンクを大文字と小文字や Unicode の正規化形式の違いを無視して照合した場合なども出力します。
+	-access-log
+		リクエストごとのログを標準エラー出力の代わりに書き込むファイルを指定します。起動時のメッセージやエラーは標準エラー出力のままです。
+	-access-log-max-size
+		-access-log のファイルを切り替えるサイズ (MB) を指定します。デフォルトは 100 です。
+		超えるとファイル名の末尾に .1 を付けて 1 世代だけ残し、新しいファイルに書き込みます。0 にすると切り替えません。
+	-metrics-token
+		/metrics で公開する Prometheus のメトリクスの取得に必要な Bearer トークンを指定します。
+		/metrics には Basic 認証をかけないため、省略すると誰でも取得できます。
+	-pdf-command
+		文書を PDF に変換するコマンドを指定します。HTML を標準入力から読み込み、PDF を標準出力に書き出すコマンド
+		("wkhtmltopdf --quiet - -" など) を指定すると、/pdf/<ファイル名>.md で文書を PDF でダウンロードできます。
+		コマンドは画像やスタイルシートを -base-url から、省略した場合は待ち受けるアドレス (-host が空なら localhost) から読み込みます。
+		デフォルトは空で、PDF での出力を無効にします。
+	-robots
+		/robots.txt でクローラーに全てのページの巡回を許可する (allow) か、禁止する (disallow) かを指定します。
+		デフォルトは disallow です。インターネットに公開して検索エンジンに登録させたい場合だけ allow にしてください。
+	-css
+		追加のスタイルシートのファイルを指定します。/custom.css で配信し、全てのページで doc.css の後に読み込むため、組み込みのスタイルを上書きできます。
+	-max-file-size
+		メモリに読み込んで応答するファイルのサイズの上限 (MB) を指定します。デフォルトは 100 で、0 にすると制限しません。
+		-zip の ZIP ファイル内の画像やファイルは上限を超えると 413 を応答し、縮小や WebP への変換をする画像は上限を超えると変換せずにそのまま応答します。
+		ZIP ファイルを使わない場合、画像やファイルはメモリに読み込まずにストリーミングするため、上限はありません。
+	-image-cache-size
+		縮小や WebP への変換をした画像をメモリに保持するキャッシュの上限 (MB) を指定します。デフォルトは 64 です。
+		上限を超えると、最近使っていない画像から捨てます。
+	-pdf-viewer
+		文書中の PDF の添付ファイルへのリンクを、サイトのヘッダーとダウンロードのリンクを付けたページ (/view/<ファイル名>) に埋め込んで表示します。
+		デフォルトは false で、PDF をブラウザでそのまま表示します。
+	-favicon
+		/favicon.ico で配信するアイコンのファイル (.ico または .png) を指定します。省略すると /favicon.ico は 404 を応答します。
+	-index-template
+		一覧のページのテンプレート (html/template 形式) のファイルを指定します。省略した場合と、読み込めないか解析できない場合は組み込みのテンプレートを使います。
+	-doc-template
+		文書のページのテンプレート (html/template 形式) のファイルを指定します。省略した場合と、読み込めないか解析できない場合は組み込みのテンプレートを使います。
+	-emoji
+		追加する絵文字の辞書を {"shortcode":"emoji"} 形式の JSON ファイルで指定します。組み込みの辞書 (GitHub の gemoji) より優先されます。
+
+The environment variables are:
+
+	全てのフラグは DOCBASEVIEW_ で始まる環境変数でも指定できます。環境変数の名前はフラグの名前を大文字にして - を _ に置き換えたもの
+	(-sort は DOCBASEVIEW_SORT、-metrics-token は DOCBASEVIEW_METRICS_TOKEN) ですが、以下のフラグは別の名前です。
+	コマンドラインでフラグを指定した場合はそちらを優先します。
+
+	-p
+		DOCBASEVIEW_PORT (なければ PORT)
+	-host
+		DOCBASEVIEW_HOST (なければ HOST)
+	-m
+		DOCBASEVIEW_MD_DIR
+	-i
+		DOCBASEVIEW_IMG_DIR
+	-f
+		DOCBASEVIEW_FILE_DIR
+	-bu
+		DOCBASEVIEW_BASIC_USER
+	-bp
+		DOCBASEVIEW_BASIC_PASSWORD (-bp より優先します)
+	-bp-file
+		DOCBASEVIEW_BASIC_PASSWORD_FILE
 */
 package main
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/subtle"
 	_ "embed"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"log"
+	"log/slog"
+	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/parser"
+	"github.com/gomarkdown/markdown/ast"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 var (
@@ -44,111 +204,528 @@ var (
 	indexHTML []byte
 	//go:embed doc.gohtml
 	docHTML []byte
+	//go:embed search.gohtml
+	searchHTML []byte
+	//go:embed brokenlinks.gohtml
+	brokenLinksHTML []byte
+	//go:embed gallery.gohtml
+	galleryHTML []byte
+	//go:embed all.gohtml
+	allHTML []byte
+	//go:embed view.gohtml
+	viewHTML []byte
+	//go:embed notfound.gohtml
+	notFoundHTML []byte
 	//go:embed doc.css
 	docCSS []byte
+	//go:embed theme.js
+	themeJS []byte
+	//go:embed copy.js
+	copyJS []byte
+	//go:embed render.js
+	renderJS []byte
+	//go:embed filter.js
+	filterJS []byte
+	//go:embed shortcuts.js
+	shortcutsJS []byte
+	//go:embed emoji.json
+	gemojiJSON []byte
+
+	indexTemplate, documentTemplate, searchTemplate *template.Template
+	notFoundTemplate, brokenLinksTemplate           *template.Template
+	galleryTemplate, allTemplate, viewTemplate      *template.Template
+	basicUser, basicPassword                        string
+	authUsers                                       map[string]string
+	authRealm                                       string
+	mdDir, imgDir, fileDir                          string
+	sortOrder                                       string
+	siteTitle, siteLogo                             string
+	keyboardShortcuts                               bool
+	customCSS                                       []byte // -css で指定したスタイルシート
 
-	indexTemplate, documentTemplate *template.Template
-	basicUser, basicPassword        string
-	mdDir, imgDir, fileDir          string
-	mdEntries                       []document
-
-	imgLinkToNameMap  = make(map[string]string)
-	fileLinkToNameMap = make(map[string]string)
-	mdLinkPattern     = regexp.MustCompile(`#{([0-9]+)}`)
-	fileLinkPattern   = regexp.MustCompile(`https://docbase\.io/file_attachments/([0-9a-zA-Z.]+)`)
-	fileIconPattern   = regexp.MustCompile(`!\[[a-z]+]\(/images/file_icons/[a-z]+\.svg\)`)
-	imgLinkPattern    = regexp.MustCompile(`https://image\.docbase\.io/uploads/([0-9a-zA-Z-.]+)[^)]*`)
+	// indexMutex は起動時やファイルの変更時に走査して作るデータを保護します。
+	indexMutex        sync.RWMutex
+	mdEntries         []document
+	mdNameToPathMap   map[string]string
+	mdNameToTitleMap  map[string]string
+	mdPathToIndexMap  map[string]int
+	imgLinkToNameMap  map[string]linkedFile
+	imgLinkToDocMap   map[string]document
+	mdBacklinksMap    map[string][]document
+	mdRelatedMap      map[string][]document
+	fileLinkToNameMap map[string]linkedFile
+
+	mdLinkPattern   = regexp.MustCompile(`#\{([0-9]+)\}|(^|[^\w&/#])#([0-9]+)\b`)
+	headingPattern  = regexp.MustCompile(`^#{1,6}(\s|$)`)
+	fileLinkPattern = regexp.MustCompile(`https://docbase\.io/file_attachments/([0-9a-zA-Z.]+)`)
+	fileIconPattern = regexp.MustCompile(`!\[[a-z]+]\(/images/file_icons/[a-z]+\.svg\)`)
+	imgLinkPattern  = regexp.MustCompile(`https://image\.docbase\.io/uploads/([0-9a-zA-Z-.]+)[^)]*`)
 )
 
+// indexPageSize は一覧の 1 ページあたりの文書の数のデフォルトです。?per= で変更でき、?per=all で全ての文書を表示します。
+const indexPageSize = 100
+
+// robotsTexts は -robots の値に対応する /robots.txt の内容です。
+var robotsTexts = map[string][]byte{
+	"allow":    []byte("User-agent: *\nDisallow:\n"),
+	"disallow": []byte("User-agent: *\nDisallow: /\n"),
+}
+
+// contentTypes は拡張子に対応する Content-Type です。
+// システムの設定 (/etc/mime.types など) がない環境では内容から推測することになり、SVG が text/xml、
+// CSV が text/plain、Office のファイルが application/octet-stream などと誤って判定されるため、システムの設定に関係なく登録します。
+// また、動画の Range リクエストでのシークにも正しい Content-Type が必要です。
+var contentTypes = map[string]string{
+	".csv":  "text/csv; charset=utf-8",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".gif":  "image/gif",
+	".ico":  "image/x-icon",
+	".jpeg": "image/jpeg",
+	".jpg":  "image/jpeg",
+	".json": "application/json",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".mp4":  "video/mp4",
+	".pdf":  "application/pdf",
+	".png":  "image/png",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	".svg":  "image/svg+xml",
+	".webm": "video/webm",
+	".webp": "image/webp",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
 type document struct {
-	FileName string
-	Title    string
+	FileName string    `json:"fileName"`
+	Title    string    `json:"title"`
+	Author   string    `json:"author,omitempty"`
+	Tags     []string  `json:"tags,omitempty"`
+	ModTime  time.Time `json:"modTime"`
+	Created  time.Time `json:"created"` // front matter の作成日時、なければ ModTime
+}
+
+// documentSorters は -sort フラグや sort クエリで指定できる文書の並び順です。
+// エクスポートしたファイルの更新日時はエクスポートした日時になってしまうため、mtime と -mtime は front matter の作成日時を優先します。
+var documentSorters = map[string]func(a, b document) bool{
+	"title":  func(a, b document) bool { return a.Title < b.Title },
+	"name":   func(a, b document) bool { return a.FileName < b.FileName },
+	"mtime":  func(a, b document) bool { return a.Created.Before(b.Created) },
+	"-mtime": func(a, b document) bool { return a.Created.After(b.Created) },
+}
+
+// sortDocuments は指定した順に並べ替えた文書のコピーを返します。
+func sortDocuments(docs []document, order string) []document {
+	sorted := make([]document, len(docs))
+	copy(sorted, docs)
+	less := documentSorters[order]
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+	return sorted
 }
 
 func main() {
 	port := flag.Int("p", 8080, "port to listen")
+	host := flag.String("host", "", "host address to listen, empty to listen on all interfaces")
+	readTimeout := flag.Duration("read-timeout", 30*time.Second, "maximum duration to read a request, 0 for no timeout")
+	writeTimeout := flag.Duration("write-timeout", 10*time.Minute, "maximum duration to write a response, 0 for no timeout")
+	idleTimeout := flag.Duration("idle-timeout", 2*time.Minute, "maximum duration to wait for the next request on keep-alive connections, 0 for no timeout")
 	flag.StringVar(&basicUser, "bu", "", "user of the basic auth, empty to disable")
-	flag.StringVar(&basicPassword, "bp", "", "password of the basic auth")
+	flag.StringVar(&basicPassword, "bp", "", "password of the basic auth, prefer -bp-file or DOCBASEVIEW_BASIC_PASSWORD")
+	passwordFile := flag.String("bp-file", "", "file containing the password of the basic auth, overrides -bp")
 	flag.StringVar(&mdDir, "m", "md", "directory of the exported markdown files")
 	flag.StringVar(&imgDir, "i", "img", "directory of the exported images")
 	flag.StringVar(&fileDir, "f", "file", "directory of the exported files")
+	flag.StringVar(&siteTitle, "title", "DocBase Viewer", "site name shown in the header and the page titles")
+	flag.StringVar(&siteLogo, "logo", "", "URL of the logo image shown in the header")
+	flag.BoolVar(&keyboardShortcuts, "shortcuts", true, "enable the keyboard shortcuts")
+	flag.StringVar(&sortOrder, "sort", "title", "order of the index: title, name, mtime or -mtime")
+	theme := flag.String("theme", "github", "style of the syntax highlighting")
+	flag.StringVar(&mermaidURL, "mermaid", "https://cdn.jsdelivr.net/npm/mermaid@10/dist/mermaid.min.js", "URL of mermaid.js to render diagrams, empty to disable")
+	csp := flag.String("csp", "", "Content-Security-Policy of the responses, empty to allow the scripts from -mermaid and -katex")
+	katex := flag.String("katex", "https://cdn.jsdelivr.net/npm/katex@0.16.9/dist/", "URL or local directory of the KaTeX distribution to render math, empty to disable")
+	flag.BoolVar(&externalNewTab, "external-new-tab", true, "open external links in a new tab")
+	sanitize := flag.Bool("sanitize", false, "sanitize raw HTML in the documents")
+	flag.IntVar(&feedLimit, "feed-limit", 20, "number of the documents in the feed")
+	flag.StringVar(&baseURL, "base-url", "", "base URL of the feed, the sitemap and the OpenSearch description, empty to use the request host")
+	flag.BoolVar(&pdfViewer, "pdf-viewer", false, "open linked PDF files in a page with the site header and a download link")
+	imageCacheSizeMB := flag.Int64("image-cache-size", 64, "maximum size in megabytes of the resized and converted images kept in memory")
+	maxFileSizeMB := flag.Int64("max-file-size", 100, "maximum size in megabytes of the files read into memory (images to resize and files in -zip), 0 for no limit")
+	flag.StringVar(&pdfCommand, "pdf-command", "", "command converting HTML on stdin to PDF on stdout to serve /pdf/, empty to disable")
+	flag.BoolVar(&webpEnabled, "webp", true, "convert JPEG and PNG images to WebP for the browsers accepting it")
+	lang := flag.String("lang", "en", "language of the pages: en or ja")
+	robots := flag.String("robots", "disallow", "crawlers allowed by /robots.txt: allow or disallow")
+	cssFile := flag.String("css", "", "stylesheet file served at /custom.css and linked after doc.css")
+	faviconFile := flag.String("favicon", "", "icon file (.ico or .png) to serve at /favicon.ico")
+	indexTemplateFile := flag.String("index-template", "", "template file of the index page, empty to use the embedded one")
+	docTemplateFile := flag.String("doc-template", "", "template file of the document pages, empty to use the embedded one")
+	emojiFile := flag.String("emoji", "", "JSON file of additional emoji shortcodes")
+	flag.StringVar(&authRealm, "auth-realm", "ログインしてください", "realm of the basic auth shown in the login prompt of the browsers")
+	authFile := flag.String("auth", "", "htpasswd file of the basic auth users (bcrypt only), overrides -bu and -bp")
+	zipFile := flag.String("zip", "", "DocBase export ZIP file to serve without extracting, -m, -i and -f are the directories in it")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", true, "follow symbolic links to directories and files in the export directories")
+	watchDirs := flag.Bool("watch", false, "rescan the directories when files are changed")
+	certFile := flag.String("cert", "", "certificate file to serve HTTPS, requires -key")
+	keyFile := flag.String("key", "", "private key file to serve HTTPS, requires -cert")
+	flag.StringVar(&metricsToken, "metrics-token", "", "bearer token to scrape /metrics, empty to allow without authentication")
+	rate := flag.Float64("rate", 0, "maximum requests per second from a client IP address, 0 for no limit")
+	rateBurst := flag.Int("rate-burst", 50, "maximum requests at once from a client IP address when -rate is set")
+	flag.BoolVar(&trustProxy, "trust-proxy", false, "use the X-Forwarded-For or X-Real-IP header as the client IP address")
+	logFormat := flag.String("log-format", "text", "format of the logs: text or json")
+	logLevel := flag.String("log-level", "info", "minimum level of the logs: debug, info, warn or error")
+	accessLog := flag.String("access-log", "", "file to write the request logs, empty to write to stderr")
+	accessLogMaxSize := flag.Int64("access-log-max-size", 100, "size in megabytes to rotate the access log file, 0 to disable rotation")
+	configFile := flag.String("config", "", "YAML file of the options, overridden by the flags and the environment variables")
 	flag.Parse()
-	if sp := os.Getenv("PORT"); len(sp) > 0 {
-		if p, err := strconv.Atoi(sp); err == nil {
-			*port = p
+	if err := mergeOptions(*configFile); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if err := initLogger(*logFormat, *logLevel); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if len(*accessLog) > 0 {
+		if err := initAccessLog(*accessLog, *logFormat, *accessLogMaxSize*1024*1024); err != nil {
+			log.Fatalf("failed to open access log %s: %v", *accessLog, err)
 		}
 	}
-
-	// scan md dir
-	mdDirEntries, err := os.ReadDir(mdDir)
-	if err != nil {
-		log.Fatalf("failed to read markdown directory %s: %v", mdDir, err)
+	if err := loadBasicPassword(*passwordFile); err != nil {
+		log.Fatalf("failed to read password file %s: %v", *passwordFile, err)
 	}
-	for _, entry := range mdDirEntries {
-		if !entry.IsDir() {
-			e := document{FileName: entry.Name()}
-			if e.Title, err = head(path.Join(mdDir, entry.Name())); err != nil {
-				log.Printf("failed to read title of %s: %v", path.Join(mdDir, entry.Name()), err)
-			}
-			mdEntries = append(mdEntries, e)
+	if (len(*certFile) > 0) != (len(*keyFile) > 0) {
+		log.Fatalf("both -cert and -key are required to serve HTTPS")
+	}
+	if len(*authFile) > 0 {
+		users, err := loadAuthUsers(*authFile)
+		if err != nil {
+			log.Fatalf("failed to load auth file %s: %v", *authFile, err)
+		}
+		authUsers = users
+	}
+	if _, ok := documentSorters[sortOrder]; !ok {
+		log.Fatalf("unknown sort order: %s", sortOrder)
+	}
+	messages, ok := uiMessagesByLang[*lang]
+	if !ok {
+		log.Fatalf("unknown language: %s", *lang)
+	}
+	ui = messages
+	robotsTXT, ok := robotsTexts[*robots]
+	if !ok {
+		log.Fatalf("unknown robots option: %s", *robots)
+	}
+	addr := net.JoinHostPort(*host, strconv.Itoa(*port))
+	pdfBaseURL = strings.TrimSuffix(baseURL, "/")
+	if len(pdfBaseURL) == 0 {
+		pdfBaseURL = listenURL(*host, *port, len(*certFile) > 0)
+	}
+	maxFileSize = *maxFileSizeMB * 1024 * 1024
+	convertedImages = newImageCache(*imageCacheSizeMB * 1024 * 1024)
+	if len(*cssFile) > 0 {
+		css, err := os.ReadFile(*cssFile)
+		if err != nil {
+			log.Fatalf("failed to read stylesheet %s: %v", *cssFile, err)
+		}
+		customCSS = css
+	}
+	faviconHandler := http.NotFound
+	if len(*faviconFile) > 0 {
+		ext := strings.ToLower(filepath.Ext(*faviconFile))
+		if ext != ".ico" && ext != ".png" {
+			log.Fatalf("favicon must be a .ico or .png file: %s", *faviconFile)
+		}
+		icon, err := os.ReadFile(*faviconFile)
+		if err != nil {
+			log.Fatalf("failed to read favicon %s: %v", *faviconFile, err)
 		}
+		faviconHandler = func(w http.ResponseWriter, r *http.Request) { write(w, r, icon, contentTypes[ext]) }
 	}
 
-	// scan img dir
-	imgDirEntries, err := os.ReadDir(imgDir)
-	if err != nil {
-		log.Fatalf("failed to read images directory %s: %v", imgDir, err)
+	if len(*zipFile) > 0 {
+		if *watchDirs {
+			log.Fatalf("-watch cannot be used with -zip")
+		}
+		if err := openZip(*zipFile); err != nil {
+			log.Fatalf("failed to open zip file %s: %v", *zipFile, err)
+		}
+	}
+
+	// scan md, img and file dir
+	if err := scanMarkdown(); err != nil {
+		log.Fatalf("failed to read markdown directory %s: %v", mdDir, err)
 	}
-	for _, entry := range imgDirEntries {
-		if !entry.IsDir() {
-			imgLinkToNameMap[entry.Name()[strings.LastIndex(entry.Name(), "_")+1:]] = entry.Name()
+	scanImages()
+	scanFiles()
+	if *watchDirs {
+		if err := watch(); err != nil {
+			log.Fatalf("failed to watch directories: %v", err)
 		}
 	}
 
-	// scan file dir
-	fileDirEntries, err := os.ReadDir(fileDir)
-	if err != nil {
-		log.Fatalf("failed to read files directory %s: %v", fileDir, err)
+	// register media types which may be missing from the system mime table
+	for ext, typ := range contentTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			log.Printf("failed to register mime type %s for %s: %v", typ, ext, err)
+		}
 	}
-	for _, entry := range fileDirEntries {
-		if !entry.IsDir() {
-			fileLinkToNameMap[entry.Name()[strings.LastIndex(entry.Name(), "_")+1:]] = entry.Name()
+
+	// load emoji
+	if len(*emojiFile) > 0 {
+		if err := loadEmoji(*emojiFile); err != nil {
+			log.Printf("WARNING: failed to load emoji file %s, using defaults: %v", *emojiFile, err)
 		}
 	}
+	if err := json.Unmarshal(gemojiJSON, &emojiTable); err != nil {
+		log.Fatalf("failed to parse embedded emoji table: %v", err)
+	}
+	for k, v := range emojiDict {
+		emojiTable[k] = v
+	}
+
+	// create highlight stylesheet
+	initHighlight(*theme)
+	initKaTeX(*katex)
+	initCSP(*csp)
+	if *sanitize {
+		initSanitize()
+	}
 
 	// create template
-	indexTemplate = template.Must(template.New("index").Parse(string(indexHTML)))
-	documentTemplate = template.Must(template.New("document").Parse(string(docHTML)))
+	indexTemplate = parseTemplate("index", *indexTemplateFile, indexHTML)
+	documentTemplate = parseTemplate("document", *docTemplateFile, docHTML)
+	searchTemplate = template.Must(template.New("search").Parse(string(searchHTML)))
+	notFoundTemplate = template.Must(template.New("notfound").Parse(string(notFoundHTML)))
+	brokenLinksTemplate = template.Must(template.New("brokenlinks").Parse(string(brokenLinksHTML)))
+	galleryTemplate = template.Must(template.New("gallery").Parse(string(galleryHTML)))
+	allTemplate = template.Must(template.New("all").Parse(string(allHTML)))
+	viewTemplate = template.Must(template.New("view").Parse(string(viewHTML)))
 
 	// start the server
+	http.HandleFunc("/healthz", handleHealth)
+	registerMetrics()
 	http.HandleFunc("/", catchAll)
-	http.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) { http.NotFound(w, r) })
+	http.HandleFunc("/search", handleSearch)
+	http.HandleFunc("/broken-links", handleBrokenLinks)
+	http.HandleFunc("/gallery", handleGallery)
+	http.HandleFunc("/all", handleAll)
+	http.HandleFunc("/view/", handleView)
+	http.HandleFunc("/api/documents", handleDocumentsAPI)
+	http.HandleFunc("/api/documents/", handleDocumentAPI)
+	http.HandleFunc("/pdf/", handlePDF)
+	http.HandleFunc("/feed.xml", handleFeed)
+	http.HandleFunc("/sitemap.xml", handleSitemap)
+	http.HandleFunc("/opensearch.xml", handleOpenSearch)
+	http.HandleFunc("/favicon.ico", faviconHandler)
+	http.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) { write(w, r, robotsTXT, "text/plain; charset=utf-8") })
 	http.HandleFunc("/doc.css", func(w http.ResponseWriter, r *http.Request) { write(w, r, docCSS, "text/css") })
-	log.Printf("server listening on port %d", *port)
-	if err := http.ListenAndServe(":"+strconv.Itoa(*port), nil); err != nil {
+	http.HandleFunc("/theme.js", func(w http.ResponseWriter, r *http.Request) { write(w, r, themeJS, "text/javascript") })
+	http.HandleFunc("/copy.js", func(w http.ResponseWriter, r *http.Request) { write(w, r, copyJS, "text/javascript") })
+	http.HandleFunc("/shortcuts.js", func(w http.ResponseWriter, r *http.Request) { write(w, r, shortcutsJS, "text/javascript") })
+	http.HandleFunc("/filter.js", func(w http.ResponseWriter, r *http.Request) { write(w, r, filterJS, "text/javascript") })
+	http.HandleFunc("/render.js", func(w http.ResponseWriter, r *http.Request) { write(w, r, renderJS, "text/javascript") })
+	if customCSS != nil {
+		http.HandleFunc("/custom.css", func(w http.ResponseWriter, r *http.Request) { write(w, r, customCSS, "text/css") })
+	}
+	http.HandleFunc("/highlight.css", func(w http.ResponseWriter, r *http.Request) { write(w, r, highlightCSS, "text/css") })
+	var limiter *rateLimiter
+	if *rate > 0 {
+		limiter = newRateLimiter(*rate, *rateBurst)
+	}
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      serverHandler(limiter, http.DefaultServeMux),
+		ReadTimeout:  *readTimeout,
+		WriteTimeout: *writeTimeout,
+		IdleTimeout:  *idleTimeout,
+	}
+	var err error
+	if len(*certFile) > 0 {
+		log.Printf("server listening on %s (HTTPS)", addr)
+		err = server.ListenAndServeTLS(*certFile, *keyFile)
+	} else {
+		log.Printf("server listening on %s", addr)
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.Fatalf("server terminated: %v", err)
 	}
 }
 
-func catchAll(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
-		return
+// scanMarkdown は Markdown ディレクトリを走査し、文書の一覧と検索用のインデックスなどを作り直します。
+func scanMarkdown() error {
+	patterns, err := readIgnorePatterns()
+	if err != nil {
+		log.Printf("WARNING: failed to read %s: %v", ignoreFileName, err)
 	}
-	if len(basicUser) > 0 {
-		if id, secret, ok := r.BasicAuth(); !ok || id != basicUser || secret != basicPassword {
-			w.Header().Set("WWW-Authenticate", `Basic realm="ログインしてください"`)
-			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
-			log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusUnauthorized)
-			return
+	var entries []document
+	nameToPath, nameToTitle, pathToIndex := make(map[string]string), make(map[string]string), make(map[string]int)
+	err = walkFollowingSymlinks(exportFS, mdDir, func(filePath string, info fs.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(mdDir, filePath)
+		if err != nil {
+			return err
 		}
+		e := document{FileName: filepath.ToSlash(rel), ModTime: info.ModTime()}
+		if ignored(patterns, e.FileName) {
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	readTitles(entries)
+	for _, e := range entries {
+		name := path.Base(e.FileName)
+		if _, ok := nameToPath[name]; !ok {
+			nameToPath[name] = e.FileName
+			nameToTitle[name] = e.Title
+		}
+	}
+	entries = sortDocuments(entries, sortOrder)
+	for i, e := range entries {
+		pathToIndex[e.FileName] = i
 	}
+	index := buildSearchIndex(entries)
+	tags := collectTags(entries)
+	broken := checkLinks(index, nameToPath)
+	backlinks := collectBacklinks(index, nameToPath)
+	related := relatedDocuments(entries)
+	imgRefs := imageReferences(index)
+
+	indexMutex.Lock()
+	defer indexMutex.Unlock()
+	mdEntries, searchIndex, tagCounts, brokenLinks = entries, index, tags, broken
+	mdNameToPathMap, mdNameToTitleMap, mdPathToIndexMap = nameToPath, nameToTitle, pathToIndex
+	imgLinkToDocMap, mdBacklinksMap, mdRelatedMap = imgRefs, backlinks, related
+	ignorePatterns = patterns
+	clearRenderCache()
+	return nil
+}
+
+// readTitles は文書のタイトルと front matter を CPU の数だけ並行して読み込みます。
+func readTitles(entries []document) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				filePath := mdFilePath(entries[i].FileName)
+				title, matter, err := head(filePath)
+				if err != nil {
+					log.Printf("failed to read title of %s: %v", filePath, err)
+				}
+				entries[i].Title, entries[i].Author, entries[i].Tags = title, matter.Author, matter.Tags
+				entries[i].Created = matter.created(entries[i].ModTime)
+			}
+		}()
+	}
+	for i := range entries {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
+
+// scanImages は画像ディレクトリを走査し、画像のリンクとファイル名の対応を作り直します。
+func scanImages() {
+	linkToName, err := scanLinkDir(imgDir)
+	if err != nil {
+		log.Printf("WARNING: failed to read images directory %s, images are disabled: %v", imgDir, err)
+	}
+	indexMutex.Lock()
+	imgLinkToNameMap = linkToName
+	indexMutex.Unlock()
+}
+
+// scanFiles はファイルディレクトリを走査し、ファイルのリンクとファイル名の対応を作り直します。
+func scanFiles() {
+	linkToName, err := scanLinkDir(fileDir)
+	if err != nil {
+		log.Printf("WARNING: failed to read files directory %s, files are disabled: %v", fileDir, err)
+	}
+	indexMutex.Lock()
+	fileLinkToNameMap = linkToName
+	indexMutex.Unlock()
+}
+
+// linkedFile はエクスポートした画像やファイルです。
+type linkedFile struct {
+	storedName  string // ディレクトリ内のファイル名 (設計書_abc123.pdf など)
+	displayName string // アップロードした時のファイル名 (設計書.pdf など)
+}
+
+// linkKey は画像やファイルの対応のキーにするため、リンクを Unicode の NFC に正規化して小文字にします。
+// 文書中のリンクとエクスポートしたファイル名で大文字と小文字が異なる場合や、macOS でエクスポートしたファイル名が
+// NFD (濁点などを分解した形式) になっている場合があるためです。
+func linkKey(link string) string {
+	return strings.ToLower(norm.NFC.String(link))
+}
+
+// lookupLinkedFile は画像やファイルをリンクの大文字と小文字や Unicode の正規化形式を区別せずに探します。
+func lookupLinkedFile(r *http.Request, linkToName map[string]linkedFile, link string) (linkedFile, bool) {
+	file, ok := linkToName[linkKey(link)]
+	if ok && !strings.HasSuffix(file.storedName, link) {
+		slog.Debug("matched file case-insensitively or by the normalized name", "request_id", requestID(r), "link", link, "file", file.storedName)
+	}
+	return file, ok
+}
+
+// scanLinkDir はディレクトリ内のファイル名から最後の _ より後ろの部分をリンクとする対応を作ります。
+// リンクは linkKey で正規化します。
+// エクスポートしたファイル名は「元のファイル名_リンク」の形式なので、最後の _ より前の部分に拡張子を付けて元のファイル名とします。
+// 同じリンクになるファイルが複数ある場合は、名前順で最後のファイルを使い、警告を出力します。
+func scanLinkDir(dir string) (map[string]linkedFile, error) {
+	linkToName, duplicates := make(map[string]linkedFile), make(map[string][]string)
+	entries, err := fs.ReadDir(exportFS, dir)
+	for _, entry := range entries {
+		if entry.Type()&fs.ModeSymlink != 0 {
+			// シンボリックリンクはリンク先がディレクトリでないことを確かめる
+			if info, err := fs.Stat(exportFS, path.Join(dir, entry.Name())); !followSymlinks || err != nil || info.IsDir() {
+				continue
+			}
+		} else if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		i := strings.LastIndex(name, "_")
+		link, displayName := name[i+1:], name[i+1:]
+		if i > 0 {
+			displayName = name[:i]
+			if ext := path.Ext(link); path.Ext(displayName) != ext {
+				displayName += ext
+			}
+		}
+		key := linkKey(link)
+		if dup, ok := linkToName[key]; ok {
+			duplicates[key] = append(duplicates[key], dup.storedName)
+		}
+		linkToName[key] = linkedFile{storedName: name, displayName: displayName}
+	}
+	for key, names := range duplicates {
+		log.Printf("WARNING: link %s in %s matches multiple files, serving %s instead of %s",
+			key, dir, linkToName[key].storedName, strings.Join(names, ", "))
+	}
+	return linkToName, err
+}
+
+func catchAll(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	// r.URL.Path はパーセントエンコーディングをデコード済み (エンコードされたままのパスは r.URL.RawPath) なので、
+	// 日本語や空白を含むファイル名もそのまま文書や画像、ファイルの対応と照合できる
 	fileName := strings.TrimPrefix(r.URL.Path, "/")
 	switch {
-	case len(fileName) == 0:
+	case len(fileName) == 0, fileName == "index.html":
+		// 静的なサイトのブックマークなどのために /index.html でも一覧を表示する (正規の URL は /)
 		handleIndex(w, r)
 	case strings.HasSuffix(strings.ToLower(fileName), ".md"):
 		handleMarkdown(w, r, fileName)
+	case strings.HasSuffix(strings.ToLower(fileName), ".md.txt"):
+		handleRawMarkdown(w, r, fileName[:len(fileName)-len(".txt")])
 	case strings.HasSuffix(strings.ToLower(fileName), ".jpg"):
 		fallthrough
 	case strings.HasSuffix(strings.ToLower(fileName), ".jpeg"):
@@ -156,132 +733,683 @@ func catchAll(w http.ResponseWriter, r *http.Request) {
 	case strings.HasSuffix(strings.ToLower(fileName), ".png"):
 		fallthrough
 	case strings.HasSuffix(strings.ToLower(fileName), ".gif"):
-		handleImage(w, r, fileName)
+		fallthrough
+	case strings.HasSuffix(strings.ToLower(fileName), ".svg"):
+		fallthrough
+	case strings.HasSuffix(strings.ToLower(fileName), ".webp"):
+		handleImage(w, r, path.Base(fileName))
 	default:
-		handleFile(w, r, fileName)
+		handleFile(w, r, path.Base(fileName))
 	}
 }
 
-func handleIndex(w http.ResponseWriter, r *http.Request) {
-	if err := indexTemplate.Execute(w, map[string]any{"Documents": mdEntries}); err != nil {
-		log.Printf("[%s] failed to write response: %v", r.RequestURI, err)
+// methodHandler は GET と HEAD 以外のリクエストに Allow ヘッダー付きで 405 を応答するハンドラーを返します。
+// 全てのパスが読み取り専用なので、登録した全てのハンドラーに同じ制限をかけます。
+// HEAD のリクエストも GET と同じように処理し、応答の本文は net/http が捨てます。
+func methodHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// serverHandler は mux にログ、リクエスト数の制限、セキュリティのヘッダー、メソッドの制限、圧縮のハンドラーを重ねます。
+func serverHandler(limiter *rateLimiter, mux http.Handler) http.Handler {
+	return logHandler(rateLimitHandler(limiter, securityHandler(methodHandler(gzipHandler(mux)))))
+}
+
+// authRealmEscaper は WWW-Authenticate ヘッダーの realm を引用符で囲むためにエスケープします。
+var authRealmEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// authorized は Basic 認証が有効な場合に資格情報を検証します。失敗した場合は 401 を応答して false を返します。
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(basicUser) == 0 && authUsers == nil {
+		return true
+	}
+	if id, secret, ok := r.BasicAuth(); !ok || !validCredential(id, secret) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+authRealmEscaper.Replace(authRealm)+`"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// validCredential は -auth のファイルが指定されていればそのユーザーで、そうでなければ -bu と -bp で資格情報を検証します。
+func validCredential(id, secret string) bool {
+	if authUsers != nil {
+		hash, ok := authUsers[id]
+		return ok && bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+	}
+	// 比較にかかる時間から資格情報を推測されないよう、両方とも常に定数時間で比較する
+	validUser := subtle.ConstantTimeCompare([]byte(id), []byte(basicUser))
+	validPassword := subtle.ConstantTimeCompare([]byte(secret), []byte(basicPassword))
+	return validUser&validPassword == 1
+}
+
+// loadBasicPassword は -bp-file のファイルまたは環境変数 DOCBASEVIEW_BASIC_PASSWORD があれば、-bp の代わりにそのパスワードを使います。
+func loadBasicPassword(filePath string) error {
+	if len(filePath) > 0 {
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return err
+		}
+		basicPassword = strings.TrimRight(string(content), "\r\n")
+		return nil
+	}
+	if password := os.Getenv(envName("bp")); len(password) > 0 {
+		basicPassword = password
+		return nil
+	}
+	if commandLineFlags["bp"] {
+		// 設定ファイルや環境変数で指定した場合はプロセスの一覧に表示されない
+		log.Printf("WARNING: -bp exposes the password to other users via the process list, use -bp-file or %s instead", envName("bp"))
+	}
+	return nil
+}
+
+// loadAuthUsers は htpasswd 形式 (user:bcrypt-hash) のファイルを読み込みます。
+func loadAuthUsers(filePath string) (map[string]string, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]string)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || len(user) == 0 {
+			return nil, fmt.Errorf("line %d: malformed entry", i+1)
+		}
+		if _, err = bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("line %d: user %s does not have a bcrypt hash: %w", i+1, user, err)
+		}
+		users[user] = hash
+	}
+	return users, nil
+}
+
+// handleHealth はロードバランサーなどの死活監視のために Basic 認証なしで応答します。
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	if _, err := fs.ReadDir(exportFS, mdDir); err != nil {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		logRequestError(r, "failed to read markdown directory %s: %v", mdDir, err)
 		return
 	}
-	log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusOK)
+	write(w, r, []byte("ok"), "text/plain; charset=utf-8")
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	indexMutex.RLock()
+	documents := mdEntries
+	if q := query.Get("sort"); len(q) > 0 && q != sortOrder {
+		if _, ok := documentSorters[q]; ok {
+			documents = sortDocuments(mdEntries, q)
+		}
+	}
+	dir := strings.Trim(query.Get("dir"), "/")
+	documents = filterByTags(filterByDir(documents, dir), query["tag"])
+	tags := tagChips(query)
+	indexMutex.RUnlock()
+	allQuery := url.Values{"tag": query["tag"]}
+	if len(dir) > 0 {
+		allQuery.Set("dir", dir)
+	}
+	data := map[string]any{
+		"Tags":            tags,
+		"Dir":             dir,
+		"SiteTitle":       siteTitle,
+		"Logo":            siteLogo,
+		"Shortcuts":       keyboardShortcuts,
+		"CustomCSS":       customCSS != nil,
+		"Msg":             ui,
+		"Total":           len(documents),
+		"Pages":           1,
+		"AllDocumentsURL": (&url.URL{Path: "all", RawQuery: allQuery.Encode()}).String(),
+		"SortURLs": map[string]string{
+			"title":  indexURL(query, "sort", "title"),
+			"name":   indexURL(query, "sort", "name"),
+			"-mtime": indexURL(query, "sort", "-mtime"),
+			"mtime":  indexURL(query, "sort", "mtime"),
+		},
+	}
+	per, err := strconv.Atoi(query.Get("per"))
+	if err != nil || per < 1 {
+		per = indexPageSize
+	}
+	if query.Get("per") != "all" && len(documents) > per {
+		page, err := strconv.Atoi(query.Get("page"))
+		pages := (len(documents) + per - 1) / per
+		if err != nil || page < 1 {
+			page = 1
+		}
+		page = min(page, pages)
+		if page > 1 {
+			data["Prev"] = indexURL(query, "page", strconv.Itoa(page-1))
+		}
+		if page < pages {
+			data["Next"] = indexURL(query, "page", strconv.Itoa(page+1))
+		}
+		data["Page"], data["Pages"], data["AllURL"] = page, pages, indexURL(query, "per", "all")
+		documents = documents[(page-1)*per : min(page*per, len(documents))]
+	}
+	data["Documents"], data["Groups"] = documents, groupByCategory(documents, dir)
+	if err := indexTemplate.Execute(w, data); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
+
+// indexURL は一覧のクエリの key を value に置き換えた URL を返します。ページ以外を変更する場合は 1 ページ目に戻します。
+func indexURL(query url.Values, key, value string) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	if key != "page" {
+		q.Del("page")
+	}
+	q.Set(key, value)
+	return "?" + q.Encode()
 }
 
 func handleMarkdown(w http.ResponseWriter, r *http.Request, fileName string) {
-	filePath := path.Join(mdDir, fileName)
-	if _, err := os.Stat(filePath); err != nil {
-		http.NotFound(w, r)
-		log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusNotFound)
+	filePath := mdFilePath(fileName)
+	info, err := statDocument(fileName)
+	if err != nil {
+		notFound(w, r)
 		return
 	}
-	title, content, err := headAndContent(filePath)
+	if r.URL.Query().Get("raw") == "1" {
+		handleRawMarkdown(w, r, fileName)
+		return
+	}
+	data, err := documentPageData(fileName, info.ModTime())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("[%s] HTTP %d failed to read %s: %v", r.RequestURI, http.StatusInternalServerError, filePath, err)
+		logRequestError(r, "failed to read %s: %v", filePath, err)
 		return
 	}
-	mdParser := parser.NewWithExtensions(parser.CommonExtensions | parser.AutoHeadingIDs)
-	htmlContent := markdown.ToHTML(fixEmoji(fixLinks([]byte(content))), mdParser, nil)
-	if err = documentTemplate.Execute(w, map[string]any{"Title": title, "HTMLContent": template.HTML(htmlContent)}); err != nil {
-		log.Printf("[%s] failed to write response: %v", r.RequestURI, err)
-		return
+	if err = documentTemplate.Execute(w, data); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
 	}
-	log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusOK)
 }
 
-func handleImage(w http.ResponseWriter, r *http.Request, fileName string) {
-	actualImageName, ok := imgLinkToNameMap[fileName]
-	if !ok {
-		http.NotFound(w, r)
-		log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusNotFound)
+// documentPageData は文書を HTML に変換し、文書のページのテンプレートに渡すデータを作ります。
+func documentPageData(fileName string, modTime time.Time) (map[string]any, error) {
+	indexMutex.RLock()
+	doc, err := renderDocument(fileName, modTime)
+	prev, next := adjacentDocument(fileName, -1), adjacentDocument(fileName, 1)
+	backlinks, related := mdBacklinksMap[fileName], mdRelatedMap[fileName]
+	indexMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"FileName":    fileName,
+		"Title":       doc.title,
+		"Author":      doc.matter.Author,
+		"Tags":        doc.matter.Tags,
+		"HTMLContent": doc.html,
+		"Root":        strings.Repeat("../", strings.Count(fileName, "/")),
+		"TOC":         doc.toc,
+		"ReadingTime": doc.readingTime,
+		"Created":     doc.matter.created(modTime),
+		"Breadcrumbs": breadcrumbs(fileName),
+		"Prev":        prev,
+		"Next":        next,
+		"Backlinks":   backlinks,
+		"Related":     related,
+		"Mermaid":     doc.mermaid,
+		"MermaidURL":  mermaidURL,
+		"Math":        doc.math,
+		"KaTeXURL":    katexBase(strings.Repeat("../", strings.Count(fileName, "/"))),
+		"SiteTitle":   siteTitle,
+		"Logo":        siteLogo,
+		"Shortcuts":   keyboardShortcuts,
+		"PDF":         len(pdfCommand) > 0,
+		"CustomCSS":   customCSS != nil,
+		"Msg":         ui,
+	}, nil
+}
+
+// filterByDir は指定したディレクトリ配下の文書を返します。
+func filterByDir(docs []document, dir string) []document {
+	if len(dir) == 0 {
+		return docs
+	}
+	var filtered []document
+	for _, doc := range docs {
+		if strings.HasPrefix(doc.FileName, dir+"/") {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered
+}
+
+// documentGroup は一覧で最初の階層のフォルダ (カテゴリ) ごとにまとめた文書です。Name が空の場合はフォルダに入っていない文書です。
+type documentGroup struct {
+	Name      string
+	Documents []document
+}
+
+// groupByCategory は文書を dir の直下のフォルダごとにまとめます。
+// グループはフォルダ名の順で、フォルダに入っていない文書のグループは最後にします。グループ内の文書の順序は変えません。
+func groupByCategory(docs []document, dir string) []documentGroup {
+	var groups []documentGroup
+	indexes := make(map[string]int)
+	for _, doc := range docs {
+		name, _, ok := strings.Cut(strings.TrimPrefix(doc.FileName, dir+"/"), "/")
+		if !ok {
+			name = ""
+		}
+		i, found := indexes[name]
+		if !found {
+			i = len(groups)
+			indexes[name] = i
+			groups = append(groups, documentGroup{Name: name})
+		}
+		groups[i].Documents = append(groups[i].Documents, doc)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		if len(groups[i].Name) == 0 || len(groups[j].Name) == 0 {
+			return len(groups[j].Name) == 0 && len(groups[i].Name) > 0
+		}
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// breadcrumb はパンくずリストの 1 項目です。Dir はそのディレクトリで絞り込んだ一覧ページのクエリに使います。
+type breadcrumb struct {
+	Name string
+	Dir  string
+}
+
+// breadcrumbs は文書のパスからディレクトリごとのパンくずリストを作成します。
+func breadcrumbs(fileName string) []breadcrumb {
+	segments := strings.Split(fileName, "/")
+	crumbs := make([]breadcrumb, 0, len(segments)-1)
+	for i := range segments[:len(segments)-1] {
+		crumbs = append(crumbs, breadcrumb{Name: segments[i], Dir: strings.Join(segments[:i+1], "/")})
+	}
+	return crumbs
+}
+
+// adjacentDocument は一覧の並び順で offset だけ離れた文書を返します。存在しない場合は nil を返します。
+func adjacentDocument(fileName string, offset int) *document {
+	i, ok := mdPathToIndexMap[fileName]
+	if !ok || i+offset < 0 || i+offset >= len(mdEntries) {
+		return nil
+	}
+	return &mdEntries[i+offset]
+}
+
+func handleRawMarkdown(w http.ResponseWriter, r *http.Request, fileName string) {
+	if _, err := statDocument(fileName); err != nil {
+		notFound(w, r)
 		return
 	}
-	imgPath := path.Join(imgDir, actualImageName)
-	content, err := os.ReadFile(imgPath)
+	filePath := mdFilePath(fileName)
+	content, err := fs.ReadFile(exportFS, filePath)
 	if err != nil {
+		if os.IsNotExist(err) {
+			notFound(w, r)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("[%s] HTTP %d failed to read %s: %v", r.RequestURI, http.StatusInternalServerError, imgPath, err)
+		logRequestError(r, "failed to read %s: %v", filePath, err)
 		return
 	}
-	write(w, r, content, http.DetectContentType(content))
+	write(w, r, content, "text/plain; charset=utf-8")
 }
 
-func handleFile(w http.ResponseWriter, r *http.Request, fileName string) {
-	actualFileName, ok := fileLinkToNameMap[fileName]
+func handleImage(w http.ResponseWriter, r *http.Request, fileName string) {
+	indexMutex.RLock()
+	image, ok := lookupLinkedFile(r, imgLinkToNameMap, fileName)
+	indexMutex.RUnlock()
 	if !ok {
-		http.NotFound(w, r)
-		log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusNotFound)
+		// SVG などは画像ではなくファイルとして添付されている場合もある
+		handleFile(w, r, fileName)
 		return
 	}
-	filePath := path.Join(fileDir, actualFileName)
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("[%s] HTTP %d failed to read %s: %v", r.RequestURI, http.StatusInternalServerError, filePath, err)
+	writeImage(w, r, path.Join(imgDir, image.storedName))
+}
+
+func handleFile(w http.ResponseWriter, r *http.Request, fileName string) {
+	indexMutex.RLock()
+	file, ok := lookupLinkedFile(r, fileLinkToNameMap, fileName)
+	indexMutex.RUnlock()
+	if !ok {
+		notFound(w, r)
 		return
 	}
-	write(w, r, content, http.DetectContentType(content))
+	// 表示する場合も保存した時のファイル名が元のファイル名になるように filename を付ける
+	disposition := "attachment"
+	if viewableInline(fileName) {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.displayName}))
+	writeFile(w, r, path.Join(fileDir, file.storedName))
+}
+
+// inlineTypes はブラウザで表示できるため、ダウンロードさせずに表示する Content-Type の接頭辞です。
+var inlineTypes = []string{"image/", "video/", "audio/", "text/plain", "application/pdf"}
+
+// viewableInline はファイルの拡張子からブラウザで表示できるかどうかを判定します。
+func viewableInline(fileName string) bool {
+	contentType := mime.TypeByExtension(path.Ext(fileName))
+	for _, prefix := range inlineTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// notFound は 404 のページを応答します。
+func notFound(w http.ResponseWriter, r *http.Request) {
+	root := strings.Repeat("../", strings.Count(strings.TrimPrefix(r.URL.Path, "/"), "/"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusNotFound)
+	if err := notFoundTemplate.Execute(w, map[string]any{"Path": r.URL.Path, "Root": root, "CustomCSS": customCSS != nil, "Msg": ui}); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
+
+// mdFilePath は URL 上の相対パスを Markdown ディレクトリ配下のファイルパスに変換します。
+// ".." を含むパスでも Markdown ディレクトリの外を指すことはありません。
+// ZIP ファイル内のパスにも使うため、区切り文字は常に / です。
+func mdFilePath(fileName string) string {
+	return path.Join(mdDir, path.Clean("/"+fileName))
 }
 
 func write(w http.ResponseWriter, r *http.Request, content []byte, contentType string) {
 	w.Header().Set("Content-Type", contentType)
 	if _, err := w.Write(content); err != nil {
-		log.Printf("[%s] failed to write response: %v", r.RequestURI, err)
+		logRequestError(r, "failed to write response: %v", err)
 	}
-	log.Printf("[%s] HTTP %d", r.RequestURI, http.StatusOK)
 }
 
-func head(filePath string) (string, error) {
-	f, err := os.Open(filePath)
+// maxFileSize はメモリに読み込んで応答するファイルのサイズの上限 (バイト) です。0 の場合は制限しません。
+var maxFileSize int64
+
+// exceedsMaxFileSize はファイルが maxFileSize を超えているかどうかを判定します。
+func exceedsMaxFileSize(info fs.FileInfo) bool {
+	return maxFileSize > 0 && info.Size() > maxFileSize
+}
+
+// writeFile はファイルの内容を Last-Modified と ETag ヘッダー付きでストリーミングします。
+// 条件付きリクエストや Range リクエストは http.ServeContent が処理します。
+// Content-Type は拡張子から判定し (contentTypes を優先します)、不明な場合は先頭 512 バイトから推測します。
+func writeFile(w http.ResponseWriter, r *http.Request, filePath string) {
+	f, err := exportFS.Open(filePath)
 	if err != nil {
-		return "", err
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to open %s: %v", filePath, err)
+		return
 	}
-	defer func(f *os.File) {
+	defer func(f fs.File) {
 		if err := f.Close(); err != nil {
 			log.Printf("failed to close %s: %v", filePath, err)
 		}
 	}(f)
-	scanner := bufio.NewScanner(f)
-	if scanner.Scan() {
-		return scanner.Text(), nil
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to stat %s: %v", filePath, err)
+		return
+	}
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		// ZIP ファイル内のファイルはシークできないため、Range リクエストに応えられるようにメモリに読み込む
+		if exceedsMaxFileSize(info) {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			logRequestError(r, "%s is larger than -max-file-size: %d bytes", filePath, info.Size())
+			return
+		}
+		data, err := io.ReadAll(f)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			logRequestError(r, "failed to read %s: %v", filePath, err)
+			return
+		}
+		content = bytes.NewReader(data)
+	}
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+}
+
+// maxLineLength は Markdown ファイルの 1 行の長さの上限です。
+// 機械的に生成した文書には 1 行の表や base64 の画像などで bufio.Scanner のデフォルトの 64KB を超える行があるため、大きめにします。
+const maxLineLength = 16 * 1024 * 1024
+
+// newLineScanner は maxLineLength までの行を読み込める bufio.Scanner を作ります。
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	return scanner
+}
+
+func head(filePath string) (string, frontMatter, error) {
+	f, err := exportFS.Open(filePath)
+	if err != nil {
+		return "", frontMatter{}, err
 	}
-	return "", scanner.Err()
+	defer func(f fs.File) {
+		if err := f.Close(); err != nil {
+			log.Printf("failed to close %s: %v", filePath, err)
+		}
+	}(f)
+	scanner := newLineScanner(f)
+	title, matter, _ := scanHead(scanner)
+	return title, matter, scanner.Err()
 }
 
-func headAndContent(filePath string) (head, content string, err error) {
-	var f *os.File
-	f, err = os.Open(filePath)
+// headAndContent はタイトル、front matter と、それらを除いた本文を読み込みます。
+// 本文はコードブロックの空行などが変わらないように、改行コードを LF に揃える以外はファイルの内容をそのまま返します。
+// タイトルと front matter の行末の CR は bufio.ScanLines が取り除きます。
+func headAndContent(filePath string) (head string, matter frontMatter, content string, err error) {
+	data, err := fs.ReadFile(exportFS, filePath)
 	if err != nil {
 		return
 	}
-	defer func() { err = f.Close() }()
-	scanner := bufio.NewScanner(f)
-	if scanner.Scan() {
-		head = scanner.Text()
+	scanner := newLineScanner(bytes.NewReader(data))
+	head, matter, consumed := scanHead(scanner)
+	content = strings.ReplaceAll(string(skipLines(data, consumed)), "\r\n", "\n")
+	return head, matter, content, scanner.Err()
+}
+
+// fixLinks は DocBase の画像やファイルの URL などをこのサーバーで表示できるように書き換えます。
+// DocBase の記法を説明する文書のために、コードブロックとインラインコードの中は書き換えません。
+// 文書間のリンクは、HTML の属性値やリンクテキストの中を書き換えないように、解析した後に linkDocuments で書き換えます。
+func fixLinks(input []byte, root string) []byte {
+	return []byte(replaceOutsideCode(string(input), func(s string) string {
+		s = fileLinkPattern.ReplaceAllStringFunc(s, func(m string) string {
+			return fileLink(fileLinkPattern.FindStringSubmatch(m)[1], root)
+		})
+		s = fileIconPattern.ReplaceAllString(s, "📄️")
+		s = imgLinkPattern.ReplaceAllString(s, "$1")
+		return strings.ReplaceAll(s, "/guidance/", "https://help.docbase.io/guidance/")
+	}))
+}
+
+// mdLink は文書へのリンクの HTML を返します。リンクテキストには文書のタイトルを使います。
+func mdLink(name, root string) string {
+	target, ok := mdNameToPathMap[name]
+	if !ok {
+		target = name
+	}
+	text := mdNameToTitleMap[name]
+	if len(text) == 0 {
+		text = name
+	}
+	return `🔗 <a href="` + root + target + `">` + template.HTMLEscapeString(text) + `</a>`
+}
+
+// replaceOutsideCode はコードブロックとインラインコードを除いた部分に、行ごとに f を適用します。
+// インラインコードは、段落の中で行をまたぐものも、` を含むコードのように複数の ` で囲んだものも除きます。
+func replaceOutsideCode(s string, f func(segment string) string) string {
+	lines, code := codeBlockLines(s)
+	var b strings.Builder
+	for i := 0; i < len(lines); {
+		if code[i] {
+			b.WriteString(lines[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(lines) && !code[j] {
+			j++
+		}
+		b.WriteString(replaceOutsideCodeSpans(strings.Join(lines[i:j], ""), f))
+		i = j
+	}
+	return b.String()
+}
+
+// replaceOutsideCodeSpans はコードブロックを含まない text のインラインコードを除いた部分に、行ごとに f を適用します。
+// 同じ数の ` で閉じられていない ` はインラインコードの開始とみなしません。
+func replaceOutsideCodeSpans(text string, f func(segment string) string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(text); {
+		if text[i] != '`' {
+			i++
+			continue
+		}
+		n := backtickRun(text, i)
+		end := closingBackticks(text, i+n, n)
+		if end < 0 {
+			i += n
+			continue
+		}
+		b.WriteString(replaceLines(text[last:i], f))
+		b.WriteString(text[i:end])
+		last, i = end, end
+	}
+	b.WriteString(replaceLines(text[last:], f))
+	return b.String()
+}
+
+// backtickRun は text の i 文字目から続く ` の数を返します。
+func backtickRun(text string, i int) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == '`' {
+		n++
 	}
-	for scanner.Scan() {
-		content += scanner.Text() + "\n"
+	return n
+}
+
+// closingBackticks は start 以降で n 個の ` の並びを探し、その直後の位置を返します。
+// インラインコードは段落をまたがないため、空行までに見つからない場合は -1 を返します。
+func closingBackticks(text string, start, n int) int {
+	for i := start; i < len(text); {
+		switch {
+		case text[i] == '`':
+			m := backtickRun(text, i)
+			if m == n {
+				return i + m
+			}
+			i += m
+		case text[i] == '\n' && len(strings.TrimSpace(lineAt(text, i+1))) == 0:
+			return -1
+		default:
+			i++
+		}
 	}
-	err = scanner.Err()
-	return
+	return -1
 }
 
-func fixLinks(input []byte) []byte {
-	s := string(input)
-	s = mdLinkPattern.ReplaceAllString(s, `🔗 <a href="$1.md">$1.md</a>`)
-	s = fileLinkPattern.ReplaceAllString(s, "$1")
-	s = fileIconPattern.ReplaceAllString(s, "📄️")
-	s = imgLinkPattern.ReplaceAllString(s, "$1")
-	s = strings.ReplaceAll(s, "[ ]", `<input type="checkbox" disabled></input>`)
-	s = strings.ReplaceAll(s, "[x]", `<input type="checkbox" disabled checked></input>`)
-	s = strings.ReplaceAll(s, "/guidance/", "https://help.docbase.io/guidance/")
-	return []byte(s)
+// lineAt は text の start 文字目から改行の前までを返します。
+func lineAt(text string, start int) string {
+	line, _, _ := strings.Cut(text[start:], "\n")
+	return line
 }
 
-// emojiDict は絵文字の辞書です。今のところよく使うものだけ対応します。
+// replaceLines は s の行ごとに f を適用します。f には改行を含めた行を渡します。
+func replaceLines(s string, f func(line string) string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if len(line) > 0 {
+			lines[i] = f(line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// replaceLinesOutsideCodeBlocks はコードブロックを除いた行ごとに f を適用します。f には改行を含めた行を渡します。
+func replaceLinesOutsideCodeBlocks(s string, f func(line string) string) string {
+	lines, code := codeBlockLines(s)
+	for i, line := range lines {
+		if !code[i] {
+			lines[i] = f(line)
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+var (
+	// codeFencePattern はフェンスコードブロックの開始と終了の行です。リストの中のものも含めるため、インデントは制限しません。
+	codeFencePattern = regexp.MustCompile("^[ \t]*(`{3,}|~{3,})")
+	// listItemPattern はリストの項目の行です。
+	listItemPattern = regexp.MustCompile(`^ {0,3}([-*+]|[0-9]{1,9}[.)])(\s|$)`)
+)
+
+// codeBlockLines は s を改行を含めた行に分け、それぞれの行がコードブロックかどうかを返します。
+// フェンスコードブロックは開始と同じ文字で同じ長さ以上の行で閉じるため、```` の中の ``` は閉じません。
+// 空行の後の 4 文字以上のインデントの行はインデントのコードブロックとしますが、リストの中では項目の続きとみなします。
+func codeBlockLines(s string) (lines []string, code []bool) {
+	lines = strings.SplitAfter(s, "\n")
+	code = make([]bool, len(lines))
+	fence, indented, inList, afterBlank := "", false, false, true
+	for i, line := range lines {
+		m := codeFencePattern.FindStringSubmatch(line)
+		blank := len(strings.TrimSpace(line)) == 0
+		switch {
+		case len(fence) > 0:
+			code[i] = true
+			if m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence) && len(strings.TrimSpace(line[len(m[0]):])) == 0 {
+				fence = ""
+			}
+			continue
+		case indented && (blank || isIndentedCode(line)):
+			code[i] = true
+			continue
+		case !blank && isIndentedCode(line) && afterBlank && !inList:
+			code[i], indented = true, true
+			continue
+		case m != nil:
+			code[i], fence, indented = true, m[1], false
+			continue
+		}
+		indented = false
+		if !blank && !isIndentedCode(line) {
+			inList = listItemPattern.MatchString(line)
+		}
+		afterBlank = blank
+	}
+	return lines, code
+}
+
+// isIndentedCode は行が 4 文字以上の空白かタブでインデントされているかどうかを判定します。
+func isIndentedCode(line string) bool {
+	return strings.HasPrefix(line, "    ") || strings.HasPrefix(line, "\t")
+}
+
+// emojiTable は起動時に組み込みの gemoji の辞書と emojiDict をマージして作成する絵文字の辞書です。
+var emojiTable map[string]string
+
+// emojiPattern は文字列の先頭の : で囲んだショートコードの候補に一致する正規表現です。辞書にあるかどうかは一致した後に調べます。
+var emojiPattern = regexp.MustCompile(`^:([\w+-]+):`)
+
+// emojiDict は gemoji の辞書より優先する絵文字の辞書です。
 var emojiDict = map[string]string{
 	"+1":             "👍",
 	"-1":             "👎",
@@ -303,10 +1431,92 @@ var emojiDict = map[string]string{
 	"unlock":         "🔓",
 }
 
-func fixEmoji(input []byte) []byte {
-	s := string(input)
-	for k, v := range emojiDict {
-		s = strings.ReplaceAll(s, ":"+k+":", v)
+// parseTemplate は filePath のテンプレートを解析します。
+// filePath が空の場合と、ファイルを読み込めないか解析できない場合 (警告を出力します) は埋め込みのテンプレートを使います。
+func parseTemplate(name, filePath string, embedded []byte) *template.Template {
+	if len(filePath) > 0 {
+		content, err := os.ReadFile(filePath)
+		if err == nil {
+			var t *template.Template
+			if t, err = template.New(name).Parse(string(content)); err == nil {
+				return t
+			}
+		}
+		log.Printf("WARNING: failed to load template %s, using the default: %v", filePath, err)
+	}
+	return template.Must(template.New(name).Parse(string(embedded)))
+}
+
+// loadEmoji は {"shortcode":"emoji"} 形式の JSON ファイルを読み込み、emojiDict に上書きで追加します。
+func loadEmoji(filePath string) error {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
 	}
-	return []byte(s)
+	dict := make(map[string]string)
+	if err = json.Unmarshal(content, &dict); err != nil {
+		return err
+	}
+	for k, v := range dict {
+		emojiDict[k] = v
+	}
+	return nil
+}
+
+// markEmoji は本文のテキストの :smile: 形式のショートコードを replaceEmoji で絵文字に置き換えます。
+// 解析した後のテキストのノードだけを書き換えるため、インデントや ```` のコードブロック、行をまたぐインラインコードの中もそのまま残ります。
+func markEmoji(doc ast.Node) {
+	replaceTextNodes(doc, func(text *ast.Text) []ast.Node {
+		if replaced := replaceEmoji(string(text.Literal)); replaced != string(text.Literal) {
+			text.Literal = []byte(replaced)
+		}
+		return nil
+	})
+}
+
+// replaceEmoji は :smile: 形式のショートコードを絵文字に置き換えます。辞書にないショートコードはそのまま残します。
+// 文字列を先頭から 1 回だけ走査し、辞書は : で始まるショートコードの候補ごとに引きます。
+// foo:smile:bar、:a:smile:、/:smile: のように英数字や : や / に隣接するものは URL や時刻などの一部とみなして置き換えませんが、
+// :+1::+1: のように続けて書いたショートコードは、隣接する : が別のショートコードの一部なので置き換えます。
+func replaceEmoji(s string) string {
+	var b strings.Builder
+	last := 0 // 書き出し済みの位置で、0 でなければ直前に置き換えたショートコードの終わりの位置
+	for i := 0; i < len(s); i++ {
+		emoji, end, ok := emojiAt(s, i)
+		if !ok {
+			continue
+		}
+		if i > 0 && isEmojiAdjacent(s[i-1]) && !(s[i-1] == ':' && last > 0 && last == i) {
+			continue
+		}
+		if end < len(s) && isEmojiAdjacent(s[end]) {
+			if _, _, next := emojiAt(s, end); s[end] != ':' || !next {
+				continue
+			}
+		}
+		b.WriteString(s[last:i])
+		b.WriteString(emoji)
+		last, i = end, end-1
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// emojiAt は s の i 文字目から辞書にあるショートコードが始まる場合に、その絵文字とショートコードの終わりの位置を返します。
+func emojiAt(s string, i int) (emoji string, end int, ok bool) {
+	if s[i] != ':' {
+		return "", 0, false
+	}
+	m := emojiPattern.FindStringSubmatchIndex(s[i:])
+	if m == nil {
+		return "", 0, false
+	}
+	emoji, ok = emojiTable[s[i+m[2]:i+m[3]]]
+	return emoji, i + m[1], ok
+}
+
+// isEmojiAdjacent はショートコードの前後にあると置き換えない文字 (ASCII の英数字、_、:、/) かどうかを判定します。
+// 日本語の文中のショートコードは置き換えるため、ASCII 以外の文字は含めません。
+func isEmojiAdjacent(c byte) bool {
+	return c == '_' || c == ':' || c == '/' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
 }
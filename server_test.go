@@ -0,0 +1,300 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestFixLinks(t *testing.T) {
+	setupRender(t)
+	mdNameToPathMap = map[string]string{"123.md": "team/123.md", "5.md": "5.md", "333.md": "333.md"}
+	mdNameToTitleMap = map[string]string{"123.md": "議事録 #{5}", "5.md": "設計方針"}
+	t.Cleanup(func() {
+		mdNameToPathMap, mdNameToTitleMap = nil, nil
+	})
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		links int // 期待する <a の数
+	}{
+		{"braces", "see #{5}", `see 🔗 <a href="/5.md">設計方針</a>`, 1},
+		{"short", "see #5.", `see 🔗 <a href="/5.md">設計方針</a>.`, 1},
+		{"line start", "#5", `🔗 <a href="/5.md">設計方針</a>`, 1},
+		{"title with braces", "see #{123}", `see 🔗 <a href="/team/123.md">議事録 #{5}</a>`, 1},
+		{"short title with braces", "see #123", `see 🔗 <a href="/team/123.md">議事録 #{5}</a>`, 1},
+		{"unknown", "#{9}", `🔗 <a href="/9.md">9.md</a>`, 1},
+		{"anchor", "[text](#5)", `<a href="#5">text</a>`, 1},
+		{"heading", "## #5 の続き", "#5 の続き", 1},
+		{"heading braces", "## #{5}", `🔗 <a href="/5.md">設計方針</a>`, 2},
+		{"section", "see #section", "see #section", 0},
+		{"word", "C#5 and issue#5", "C#5 and issue#5", 0},
+		{"code span", "`#{5}` and `#5`", "<code>#{5}</code> and <code>#5</code>", 0},
+		{"fenced code", "```\n#{5}\n#5\n```", "#{5}\n#5", 0},
+		{"html attribute", `<span style="color:#333">red</span>`, `<span style="color:#333">red</span>`, 0},
+		{"html anchor", `<a href="#5">x</a>`, `<a href="#5">x</a>`, 1},
+		{"link text", "[#5](http://example.com)", `<a href="http://example.com">#5</a>`, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rendered := renderContent(tt.input, "/")
+			got := string(rendered)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("renderContent(%q) = %q, want to contain %q", tt.input, got, tt.want)
+			}
+			if links := strings.Count(got, "<a "); links != tt.links {
+				t.Errorf("renderContent(%q) = %q, want %d links", tt.input, got, tt.links)
+			}
+		})
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("htpasswd-secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	htpasswd := filepath.Join(t.TempDir(), ".htpasswd")
+	if err = os.WriteFile(htpasswd, []byte("# users\nalice:"+string(hash)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	users, err := loadAuthUsers(htpasswd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		basicUser, basicPassword, authUsers = "", "", nil
+	})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			_, _ = w.Write([]byte("ok"))
+		}
+	})
+	tests := []struct {
+		name     string
+		users    map[string]string
+		user     string
+		password string
+		noHeader bool
+		want     int
+	}{
+		{name: "password", user: "admin", password: "secret", want: http.StatusOK},
+		{name: "wrong password", user: "admin", password: "wrong", want: http.StatusUnauthorized},
+		{name: "wrong user", user: "guest", password: "secret", want: http.StatusUnauthorized},
+		{name: "missing header", noHeader: true, want: http.StatusUnauthorized},
+		{name: "htpasswd", users: users, user: "alice", password: "htpasswd-secret", want: http.StatusOK},
+		{name: "htpasswd wrong password", users: users, user: "alice", password: "secret", want: http.StatusUnauthorized},
+		{name: "htpasswd unknown user", users: users, user: "admin", password: "secret", want: http.StatusUnauthorized},
+		{name: "htpasswd missing header", users: users, noHeader: true, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			basicUser, basicPassword, authUsers = "admin", "secret", tt.users
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if !tt.noHeader {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (len(challenge) > 0) {
+				t.Errorf("WWW-Authenticate = %q with status %d", challenge, w.Code)
+			}
+		})
+	}
+}
+
+func TestCatchAllEncodedName(t *testing.T) {
+	imgDir, fileDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		imgDir, fileDir = "img", "file"
+		imgLinkToNameMap, fileLinkToNameMap = nil, nil
+	})
+	files := map[string]string{
+		filepath.Join(fileDir, "仕様書_設計.pdf"):    "%PDF",
+		filepath.Join(fileDir, "議事録_会議 メモ.txt"): "memo",
+		filepath.Join(imgDir, "構成図_図.png"):      "png",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var err error
+	if imgLinkToNameMap, err = scanLinkDir(imgDir); err != nil {
+		t.Fatal(err)
+	}
+	if fileLinkToNameMap, err = scanLinkDir(fileDir); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"/%E8%A8%AD%E8%A8%88.pdf", "%PDF"},
+		{"/%E4%BC%9A%E8%AD%B0%20%E3%83%A1%E3%83%A2.txt", "memo"},
+		{"/%E5%9B%B3.png", "png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			catchAll(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			if w.Code != http.StatusOK || w.Body.String() != tt.want {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.target, w.Code, w.Body.String(), http.StatusOK, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkKey(t *testing.T) {
+	nfc := "\u30ac\u30a4\u30c9.PNG"             // ガイド.PNG (濁点を合成した形式)
+	nfd := "\u30ab\u3099\u30a4\u30c8\u3099.png" // ガイド.png (macOS のファイル名のように濁点を分解した形式)
+	if linkKey(nfc) != linkKey(nfd) {
+		t.Errorf("linkKey(%q) = %q, linkKey(%q) = %q, want equal", nfc, linkKey(nfc), nfd, linkKey(nfd))
+	}
+	if want := "\u30ac\u30a4\u30c9.png"; linkKey(nfd) != want {
+		t.Errorf("linkKey(%q) = %q, want %q", nfd, linkKey(nfd), want)
+	}
+	linkToName := map[string]linkedFile{linkKey(nfd): {storedName: "手順_" + nfd}}
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := lookupLinkedFile(r, linkToName, nfc); !ok {
+		t.Errorf("lookupLinkedFile(%q) did not match the NFD name", nfc)
+	}
+}
+
+// setupMarkdownDir は mdDir をテストの間だけ一時的なディレクトリにします。
+func setupMarkdownDir(t *testing.T) {
+	t.Helper()
+	dir := mdDir
+	mdDir = t.TempDir()
+	t.Cleanup(func() {
+		mdDir = dir
+	})
+}
+
+// writeDocument は mdDir に文書を書き込みます。
+func writeDocument(t *testing.T, fileName, content string) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(mdDir, fileName), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHeadAndContentCodeBlock(t *testing.T) {
+	setupMarkdownDir(t)
+	content := "```\nline 1\n\n\n```\n\n    indented\n\n\n"
+	writeDocument(t, "code.md", "# コード\n"+content)
+	head, _, got, err := headAndContent(mdFilePath("code.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head != "コード" || got != content {
+		t.Errorf("headAndContent = %q, %q, want %q, %q", head, got, "コード", content)
+	}
+}
+
+func TestHeadAndContentCRLF(t *testing.T) {
+	setupMarkdownDir(t)
+	setupRender(t)
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"heading", "# 設計方針\n本文\n\n- 項目\n"},
+		{"front matter", "---\ntitle: 設計方針\ntags: [設計]\n---\n本文\n"},
+		{"front matter without title", "---\ntags: [設計]\n---\n\n# 設計方針\n本文\n"},
+		{"code block", "# 設計方針\n```go\nfunc main() {\n\n}\n```\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeDocument(t, "lf.md", tt.input)
+			writeDocument(t, "crlf.md", strings.ReplaceAll(tt.input, "\n", "\r\n"))
+			lfHead, lfMatter, lfContent, err := headAndContent(mdFilePath("lf.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, renderedLF := renderContent(lfContent, "")
+			crlfHead, crlfMatter, crlfContent, err := headAndContent(mdFilePath("crlf.md"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if crlfHead != "設計方針" || crlfHead != lfHead {
+				t.Errorf("title = %q, want %q", crlfHead, lfHead)
+			}
+			if !reflect.DeepEqual(crlfMatter, lfMatter) {
+				t.Errorf("front matter = %+v, want %+v", crlfMatter, lfMatter)
+			}
+			if crlfContent != lfContent {
+				t.Errorf("content = %q, want %q", crlfContent, lfContent)
+			}
+			if _, crlfHTML := renderContent(crlfContent, ""); string(crlfHTML) != string(renderedLF) {
+				t.Errorf("rendered = %q, want %q", crlfHTML, renderedLF)
+			}
+		})
+	}
+}
+
+func TestReplaceOutsideCode(t *testing.T) {
+	upper := func(s string) string { return strings.ToUpper(s) }
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"text", "a `b` c", "A `b` C"},
+		{"double backticks", "a `` `b` `` c", "A `` `b` `` C"},
+		{"unclosed backtick", "a ` b", "A ` B"},
+		{"multi-line span", "a `b\nc` d", "A `b\nc` D"},
+		{"span across paragraphs", "a `b\n\nc` d", "A `B\n\nC` D"},
+		{"fence", "a\n```\nb\n```\nc", "A\n```\nb\n```\nC"},
+		{"longer fence", "a\n````\n```\nb\n```\n````\nc", "A\n````\n```\nb\n```\n````\nC"},
+		{"tilde fence", "~~~\n```\nb\n~~~\nc", "~~~\n```\nb\n~~~\nC"},
+		{"indented code", "a\n\n    b\n\n    c\nd", "A\n\n    b\n\n    c\nD"},
+		{"paragraph continuation", "a\n    b", "A\n    B"},
+		{"nested list", "- a\n\n    - b", "- A\n\n    - B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := replaceOutsideCode(tt.input, upper); got != tt.want {
+				t.Errorf("replaceOutsideCode(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerHandlerMethods(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) { write(w, r, []byte("ok"), "text/plain; charset=utf-8") })
+	handler := serverHandler(nil, mux)
+	tests := []struct {
+		method string
+		want   int
+	}{
+		{http.MethodGet, http.StatusOK},
+		{http.MethodHead, http.StatusOK},
+		{http.MethodPost, http.StatusMethodNotAllowed},
+		{http.MethodPut, http.StatusMethodNotAllowed},
+		{http.MethodDelete, http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(tt.method, "/robots.txt", nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if allow := w.Header().Get("Allow"); tt.want == http.StatusMethodNotAllowed && allow != "GET, HEAD" {
+				t.Errorf("Allow = %q, want %q", allow, "GET, HEAD")
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// キーボードショートカット。/ で絞り込みまたは検索の入力欄へ移動し、g i で一覧へ戻り、一覧では j と k で文書を選ぶ。
+// 入力欄で文字を入力している間は何もしない。
+(function () {
+    let pending = "";
+    document.addEventListener("keydown", function (event) {
+        const target = event.target;
+        if (event.ctrlKey || event.metaKey || event.altKey || target.isContentEditable ||
+            ["INPUT", "TEXTAREA", "SELECT"].includes(target.tagName)) {
+            return;
+        }
+        const index = document.querySelector("header.site a");
+        if (pending === "g") {
+            pending = "";
+            if (event.key === "i" && index) {
+                location.href = index.href;
+            }
+            return;
+        }
+        switch (event.key) {
+            case "/": {
+                const input = document.getElementById("filter") || document.querySelector("input[type=search]");
+                event.preventDefault();
+                if (input) {
+                    input.focus();
+                } else if (index) {
+                    location.href = new URL("search", index.href).href;
+                }
+                break;
+            }
+            case "g":
+                pending = "g";
+                break;
+            case "j":
+            case "k":
+                move(event.key === "j" ? 1 : -1);
+                break;
+        }
+    });
+
+    // 一覧で表示されている文書のリンクの間でフォーカスを移動する
+    function move(offset) {
+        const links = Array.from(document.querySelectorAll("ul.documents > li > a")).filter(function (a) {
+            return a.offsetParent !== null;
+        });
+        if (links.length === 0) {
+            return;
+        }
+        const current = links.indexOf(document.activeElement);
+        let next = current + offset;
+        if (current < 0) {
+            next = offset > 0 ? 0 : links.length - 1;
+        }
+        links[Math.min(Math.max(next, 0), links.length - 1)].focus();
+    }
+})();
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+)
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"http://www.sitemaps.org/schemas/sitemap/0.9 urlset"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod"`
+}
+
+// handleSitemap は全ての文書のページの URL を sitemap.xml の形式で返します。画像やファイルは含めません。
+func handleSitemap(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	base := requestBaseURL(r)
+	var sitemap sitemapURLSet
+	indexMutex.RLock()
+	for _, doc := range mdEntries {
+		sitemap.URLs = append(sitemap.URLs, sitemapURL{
+			Loc:     base + documentPath(doc.FileName),
+			LastMod: doc.ModTime.Format(time.RFC3339),
+		})
+	}
+	indexMutex.RUnlock()
+	body, err := xml.MarshalIndent(sitemap, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to encode sitemap: %v", err)
+		return
+	}
+	write(w, r, append([]byte(xml.Header), body...), "application/xml; charset=utf-8")
+}
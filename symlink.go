@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// followSymlinks はディレクトリを走査する時にシンボリックリンクのディレクトリとファイルをたどるかどうかです。
+var followSymlinks bool
+
+// walkFollowingSymlinks は fsys の root 配下のディレクトリとファイルを再帰的に走査し、それぞれについて fn を呼び出します。
+// followSymlinks の場合はシンボリックリンクをリンク先の情報で fn に渡し、リンク先のディレクトリの中も走査します。
+// そうでない場合、シンボリックリンクは無視します。
+// リンクが循環している場合に同じディレクトリを何度も走査しないよう、OS のファイルシステムでは実際のパスで走査済みのディレクトリを記録します。
+func walkFollowingSymlinks(fsys fs.FS, root string, fn func(filePath string, info fs.FileInfo) error) error {
+	visited := make(map[string]bool)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		if _, ok := fsys.(osFS); ok {
+			if real, err := realPath(dir); err == nil {
+				if visited[real] {
+					return nil
+				}
+				visited[real] = true
+			}
+		}
+		return fs.WalkDir(fsys, dir, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.Type()&fs.ModeSymlink == 0 {
+				info, err := entry.Info()
+				if err != nil {
+					return nil // 走査中に削除された
+				}
+				return fn(filePath, info)
+			}
+			if !followSymlinks {
+				return nil
+			}
+			info, err := fs.Stat(fsys, filePath)
+			if err != nil {
+				log.Printf("WARNING: failed to follow symlink %s: %v", filePath, err)
+				return nil
+			}
+			if info.IsDir() {
+				return walk(filePath)
+			}
+			return fn(filePath, info)
+		})
+	}
+	return walk(root)
+}
+
+// realPath はシンボリックリンクを解決した絶対パスを返します。
+func realPath(filePath string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(filePath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(resolved)
+}
+
+// throughSymlink は dir から fileName までのパスの途中やファイル自体にシンボリックリンクがあるかどうかを返します。
+// followSymlinks の場合と OS のファイルシステムでない場合は常に false です。
+// 走査でたどらないシンボリックリンクの文書を、直接の URL でも表示しないために使います。
+func throughSymlink(fsys fs.FS, dir, fileName string) bool {
+	if _, ok := fsys.(osFS); !ok || followSymlinks {
+		return false
+	}
+	current := dir
+	for _, segment := range strings.Split(strings.TrimPrefix(path.Clean("/"+fileName), "/"), "/") {
+		current = path.Join(current, segment)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return false // 存在しない場合は呼び出し元の Stat でエラーにする
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
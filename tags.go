@@ -0,0 +1,140 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+)
+
+// tagCount はタグとそのタグが付いた文書の数です。
+type tagCount struct {
+	Name  string
+	Count int
+}
+
+// tagChip は一覧ページに表示するタグです。URL はそのタグの選択状態を切り替えた一覧ページのクエリです。
+type tagChip struct {
+	tagCount
+	Selected bool
+	URL      string
+}
+
+var tagCounts []tagCount
+
+// collectTags は全ての文書のタグを集計します。
+func collectTags(docs []document) []tagCount {
+	counts := make(map[string]int)
+	for _, doc := range docs {
+		for _, tag := range doc.Tags {
+			counts[tag]++
+		}
+	}
+	tags := make([]tagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, tagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i].Name < tags[j].Name })
+	return tags
+}
+
+// relatedLimit は文書ごとに表示する関連する文書の最大数です。
+const relatedLimit = 5
+
+// relatedDocuments は文書ごとに、共通するタグが多い順 (同じ数の場合は作成日時の新しい順) に最大 relatedLimit 件の他の文書を選びます。
+// 共通するタグがない文書は含めません。
+func relatedDocuments(docs []document) map[string][]document {
+	tagged := make(map[string][]int)
+	for i, doc := range docs {
+		for _, tag := range doc.Tags {
+			tagged[tag] = append(tagged[tag], i)
+		}
+	}
+	related := make(map[string][]document)
+	for i, doc := range docs {
+		shared := make(map[int]int)
+		for _, tag := range doc.Tags {
+			for _, j := range tagged[tag] {
+				if j != i {
+					shared[j]++
+				}
+			}
+		}
+		if len(shared) == 0 {
+			continue
+		}
+		candidates := make([]int, 0, len(shared))
+		for j := range shared {
+			candidates = append(candidates, j)
+		}
+		sort.Slice(candidates, func(a, b int) bool {
+			ca, cb := candidates[a], candidates[b]
+			if shared[ca] != shared[cb] {
+				return shared[ca] > shared[cb]
+			}
+			if !docs[ca].Created.Equal(docs[cb].Created) {
+				return docs[ca].Created.After(docs[cb].Created)
+			}
+			return docs[ca].FileName < docs[cb].FileName
+		})
+		for _, j := range candidates[:min(len(candidates), relatedLimit)] {
+			related[doc.FileName] = append(related[doc.FileName], docs[j])
+		}
+	}
+	return related
+}
+
+// filterByTags は指定した全てのタグが付いた文書を返します。
+func filterByTags(docs []document, tags []string) []document {
+	if len(tags) == 0 {
+		return docs
+	}
+	var filtered []document
+	for _, doc := range docs {
+		if hasAllTags(doc, tags) {
+			filtered = append(filtered, doc)
+		}
+	}
+	return filtered
+}
+
+func hasAllTags(doc document, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, t := range doc.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// tagChips は現在のクエリをもとに、タグごとの選択を切り替える URL を付けたタグの一覧を返します。
+func tagChips(query url.Values) []tagChip {
+	selected := make(map[string]bool)
+	for _, tag := range query["tag"] {
+		selected[tag] = true
+	}
+	chips := make([]tagChip, 0, len(tagCounts))
+	for _, tc := range tagCounts {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Del("tag")
+		q.Del("page")
+		for _, tag := range query["tag"] {
+			if tag != tc.Name {
+				q.Add("tag", tag)
+			}
+		}
+		if !selected[tc.Name] {
+			q.Add("tag", tc.Name)
+		}
+		chips = append(chips, tagChip{tagCount: tc, Selected: selected[tc.Name], URL: "?" + q.Encode()})
+	}
+	return chips
+}
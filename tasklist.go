@@ -0,0 +1,42 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// taskMarkerPattern はタスクリストの項目の先頭の [ ] または [x] です。
+var taskMarkerPattern = regexp.MustCompile(`^\[([ xX])\](\s|$)`)
+
+// markTaskLists は GitHub と同様に、リストの項目の先頭の [ ] と [x] を無効にしたチェックボックスに置き換えます。
+// 本文やコード中の [x] はそのまま残します。
+func markTaskLists(doc ast.Node) {
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		item, ok := node.(*ast.ListItem)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		para, ok := ast.GetFirstChild(item).(*ast.Paragraph)
+		if !ok {
+			return ast.GoToNext
+		}
+		text, ok := ast.GetFirstChild(para).(*ast.Text)
+		if !ok {
+			return ast.GoToNext
+		}
+		m := taskMarkerPattern.FindSubmatch(text.Literal)
+		if m == nil {
+			return ast.GoToNext
+		}
+		checkbox := `<input type="checkbox" disabled> `
+		if m[1][0] != ' ' {
+			checkbox = `<input type="checkbox" disabled checked> `
+		}
+		text.Literal = text.Literal[len(m[0]):]
+		span := &ast.HTMLSpan{Leaf: ast.Leaf{Literal: []byte(checkbox)}}
+		span.SetParent(para)
+		para.SetChildren(append([]ast.Node{span}, para.GetChildren()...))
+		return ast.GoToNext
+	})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMarkTaskLists(t *testing.T) {
+	setupRender(t)
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:  "items",
+			input: "- [ ] todo\n- [x] done\n- [X] done\n",
+			want:  []string{`<input type="checkbox" disabled> todo`, `<input type="checkbox" disabled checked> done`},
+		},
+		{
+			name:    "code span",
+			input:   "- `[x]` is a checked box\n- use `- [ ]` for a task\n",
+			want:    []string{"<code>[x]</code> is a checked box", "<code>- [ ]</code> for a task"},
+			notWant: []string{"<input"},
+		},
+		{
+			name:    "fenced code",
+			input:   "```\n- [ ] todo\n- [x] done\n```\n",
+			want:    []string{"- [ ] todo\n- [x] done"},
+			notWant: []string{"<input"},
+		},
+		{
+			name:    "list item in fence",
+			input:   "- example\n\n  ```\n  [x] done\n  ```\n",
+			want:    []string{"[x] done"},
+			notWant: []string{"<input"},
+		},
+		{
+			name:    "text",
+			input:   "- see [x] in the middle\n",
+			notWant: []string{"<input"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rendered := renderContent(tt.input, "")
+			got := string(rendered)
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("rendered %q does not contain %q", got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("rendered %q contains %q", got, notWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+// ダークモードの切り替え。選択したテーマは localStorage に保存し、未選択の場合は OS の設定に従う。
+(function () {
+    const saved = localStorage.getItem("theme");
+    if (saved) {
+        document.documentElement.dataset.theme = saved;
+    }
+    document.addEventListener("DOMContentLoaded", function () {
+        const button = document.getElementById("theme-toggle");
+        if (!button) {
+            return;
+        }
+        button.addEventListener("click", function () {
+            const current = document.documentElement.dataset.theme ||
+                (matchMedia("(prefers-color-scheme: dark)").matches ? "dark" : "light");
+            const next = current === "dark" ? "light" : "dark";
+            document.documentElement.dataset.theme = next;
+            localStorage.setItem("theme", next);
+        });
+    });
+})();
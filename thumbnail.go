@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailWidths は ?w= で指定した幅を切り上げる幅です。任意の幅でキャッシュが増えないように、この幅にだけ縮小します。
+var thumbnailWidths = []int{100, 200, 300, 400, 600, 800, 1200, 1600, 2000}
+
+// maxImagePixels は縮小や変換のために展開する画像の画素数の上限です。展開するとメモリを大量に使う画像はそのまま応答します。
+const maxImagePixels = 50_000_000
+
+// webpEnabled は WebP に対応したブラウザに JPEG と PNG の画像を WebP に変換して応答するかどうかです。
+var webpEnabled bool
+
+// convertedImage は縮小や WebP への変換をした画像です。元の画像の更新日時が変わるまで再利用します。
+type convertedImage struct {
+	modTime     time.Time
+	data        []byte
+	contentType string
+}
+
+type convertedImageKey struct {
+	name  string
+	width int
+	webp  bool
+}
+
+// convertedImageEntrySize は変換した画像のデータ以外にキャッシュのエントリが使うおおよそのバイト数です。
+const convertedImageEntrySize = 256
+
+// imageCache は変換した画像を合計のバイト数が limit 以下になるように、最近使っていないものから捨てて保持するキャッシュです。
+// 同じ画像の変換が同時に要求された場合は、最初の 1 つだけが変換して結果を共有します。
+type imageCache struct {
+	mutex    sync.Mutex
+	limit    int64
+	size     int64
+	entries  map[convertedImageKey]*list.Element
+	order    *list.List // 最近使ったものが先頭
+	inflight map[convertedImageKey]*imageConversion
+}
+
+type imageCacheEntry struct {
+	key   convertedImageKey
+	image convertedImage
+	size  int64
+}
+
+// imageConversion は実行中の画像の変換です。done が閉じられると image と err が決まります。
+type imageConversion struct {
+	done  chan struct{}
+	image convertedImage
+	err   error
+}
+
+// convertedImages は変換した画像のキャッシュです。limit は -image-cache-size で設定します。
+var convertedImages = newImageCache(0)
+
+func newImageCache(limit int64) *imageCache {
+	return &imageCache{
+		limit:    limit,
+		entries:  make(map[convertedImageKey]*list.Element),
+		order:    list.New(),
+		inflight: make(map[convertedImageKey]*imageConversion),
+	}
+}
+
+// get は更新日時が modTime のキャッシュがあればそれを返し、なければ convert で変換してキャッシュします。
+// 変換に失敗した場合も、同じ画像の変換を繰り返さないように data が nil の結果をキャッシュします。
+func (c *imageCache) get(key convertedImageKey, modTime time.Time, convert func() (convertedImage, error)) (convertedImage, error) {
+	c.mutex.Lock()
+	if e, ok := c.entries[key]; ok && e.Value.(*imageCacheEntry).image.modTime.Equal(modTime) {
+		c.order.MoveToFront(e)
+		c.mutex.Unlock()
+		return e.Value.(*imageCacheEntry).image, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mutex.Unlock()
+		<-call.done
+		return call.image, call.err
+	}
+	call := &imageConversion{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mutex.Unlock()
+
+	call.image, call.err = convert()
+	call.image.modTime = modTime
+	c.mutex.Lock()
+	delete(c.inflight, key)
+	c.add(key, call.image)
+	c.mutex.Unlock()
+	close(call.done)
+	return call.image, call.err
+}
+
+// add はキャッシュに画像を追加し、limit を超えた分を最近使っていないものから捨てます。mutex を取得して呼び出します。
+func (c *imageCache) add(key convertedImageKey, image convertedImage) {
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	size := int64(len(image.data)+len(key.name)) + convertedImageEntrySize
+	if size > c.limit {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&imageCacheEntry{key: key, image: image, size: size})
+	c.size += size
+	for c.size > c.limit {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *imageCache) remove(e *list.Element) {
+	entry := c.order.Remove(e).(*imageCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// thumbnailWidth は ?w= で指定した幅を thumbnailWidths のうちそれ以上で最小の幅に切り上げます。最大の幅より大きい場合は最大の幅にします。
+func thumbnailWidth(n int) int {
+	for _, width := range thumbnailWidths {
+		if n <= width {
+			return width
+		}
+	}
+	return thumbnailWidths[len(thumbnailWidths)-1]
+}
+
+// writeImage は ?w= で幅を指定された場合は thumbnailWidths の幅に切り上げて縦横比を維持して縮小し、WebP に対応したブラウザには WebP に変換して画像を応答します。
+// SVG やアニメーション GIF など変換できない画像と、元から指定した幅以下で変換の必要がない画像はそのまま応答します。
+func writeImage(w http.ResponseWriter, r *http.Request, filePath string) {
+	width := 0
+	if q := r.URL.Query().Get("w"); len(q) > 0 {
+		n, err := strconv.Atoi(q)
+		if err != nil || n <= 0 {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		width = thumbnailWidth(n)
+	}
+	ext := strings.ToLower(path.Ext(filePath))
+	toWebP := false
+	if webpEnabled && webpSupported && (ext == ".jpg" || ext == ".jpeg" || ext == ".png") {
+		w.Header().Add("Vary", "Accept")
+		toWebP = strings.Contains(r.Header.Get("Accept"), "image/webp")
+	}
+	if width == 0 && !toWebP {
+		writeFile(w, r, filePath)
+		return
+	}
+	info, err := fs.Stat(exportFS, filePath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		logRequestError(r, "failed to stat %s: %v", filePath, err)
+		return
+	}
+	if exceedsMaxFileSize(info) {
+		// 変換にはメモリに読み込む必要があるため、大きすぎる画像はそのまま応答する
+		writeFile(w, r, filePath)
+		return
+	}
+	key := convertedImageKey{name: filePath, width: width, webp: toWebP}
+	cached, err := convertedImages.get(key, info.ModTime(), func() (convertedImage, error) {
+		return convertImage(filePath, width, toWebP)
+	})
+	if err != nil {
+		logRequestError(r, "failed to convert %s: %v", filePath, err)
+	}
+	if cached.data == nil {
+		writeFile(w, r, filePath)
+		return
+	}
+	w.Header().Set("Content-Type", cached.contentType)
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x-w%d-%t"`, info.Size(), info.ModTime().UnixNano(), width, toWebP))
+	http.ServeContent(w, r, path.Base(filePath), info.ModTime(), bytes.NewReader(cached.data))
+}
+
+// convertImage は画像を幅 width に縮小し (0 の場合は縮小しません)、toWebP の場合は WebP で、それ以外は元と同じ形式で符号化します。
+// 変換しない画像の場合は data が nil の convertedImage を返します。
+func convertImage(filePath string, width int, toWebP bool) (convertedImage, error) {
+	ext := strings.ToLower(path.Ext(filePath))
+	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" && ext != ".gif" {
+		return convertedImage{}, nil
+	}
+	content, err := fs.ReadFile(exportFS, filePath)
+	if err != nil {
+		return convertedImage{}, err
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return convertedImage{}, err
+	}
+	if pixels := int64(config.Width) * int64(config.Height); pixels > maxImagePixels {
+		return convertedImage{}, fmt.Errorf("%d x %d pixels exceed the limit", config.Width, config.Height)
+	}
+	if ext == ".gif" {
+		// アニメーション GIF は最初のフレームだけになってしまうため縮小しない
+		anim, err := gif.DecodeAll(bytes.NewReader(content))
+		if err != nil || len(anim.Image) > 1 {
+			return convertedImage{}, err
+		}
+	}
+	img, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return convertedImage{}, err
+	}
+	resized := false
+	if bounds := img.Bounds(); width > 0 && bounds.Dx() > width {
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+		img = dst
+		resized = true
+	}
+	var buf bytes.Buffer
+	switch {
+	case toWebP:
+		if err = encodeWebP(&buf, img); err != nil {
+			return convertedImage{}, err
+		}
+		if !resized && buf.Len() >= len(content) {
+			// 小さくならない場合は元の画像のほうがよい
+			return convertedImage{}, nil
+		}
+		return convertedImage{data: buf.Bytes(), contentType: "image/webp"}, nil
+	case !resized:
+		return convertedImage{}, nil
+	case ext == ".png":
+		err = png.Encode(&buf, img)
+	case ext == ".gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return convertedImage{}, err
+	}
+	return convertedImage{data: buf.Bytes(), contentType: contentTypes[ext]}, nil
+}
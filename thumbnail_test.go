@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestThumbnailWidth(t *testing.T) {
+	tests := map[int]int{1: 100, 100: 100, 101: 200, 250: 300, 1999: 2000, 5000: 2000}
+	for n, want := range tests {
+		if got := thumbnailWidth(n); got != want {
+			t.Errorf("thumbnailWidth(%d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestImageCacheEviction(t *testing.T) {
+	entrySize := int64(1000 + len("a.png") + convertedImageEntrySize)
+	c := newImageCache(entrySize * 2)
+	modTime := time.Now()
+	conversions := 0
+	get := func(width int) {
+		_, _ = c.get(convertedImageKey{name: "a.png", width: width}, modTime, func() (convertedImage, error) {
+			conversions++
+			return convertedImage{data: make([]byte, 1000)}, nil
+		})
+	}
+	get(100)
+	get(200)
+	get(100) // 200 より最近使った
+	get(300) // 200 を捨てる
+	if conversions != 3 {
+		t.Fatalf("conversions = %d, want 3", conversions)
+	}
+	get(100)
+	if conversions != 3 {
+		t.Errorf("recently used image was evicted")
+	}
+	get(200)
+	if conversions != 4 {
+		t.Errorf("least recently used image was not evicted")
+	}
+	if c.size > c.limit {
+		t.Errorf("size = %d, want <= %d", c.size, c.limit)
+	}
+}
+
+func TestImageCacheConcurrentConversions(t *testing.T) {
+	c := newImageCache(1 << 20)
+	key := convertedImageKey{name: "a.png", width: 100}
+	modTime := time.Now()
+	release := make(chan struct{})
+	var conversions atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			image, _ := c.get(key, modTime, func() (convertedImage, error) {
+				conversions.Add(1)
+				<-release
+				return convertedImage{data: []byte("converted")}, nil
+			})
+			if string(image.data) != "converted" {
+				t.Errorf("data = %q, want %q", image.data, "converted")
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := conversions.Load(); n != 1 {
+		t.Errorf("conversions = %d, want 1", n)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"html"
+	"io"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// tocMinHeadings は目次を表示するのに必要な見出しの最小数です。
+const tocMinHeadings = 3
+
+// tocEntry は目次の 1 項目です。H2 の下に H3 をぶら下げます。
+type tocEntry struct {
+	ID       string
+	Title    string
+	Children []tocEntry
+}
+
+// tableOfContents は H2 と H3 の見出しから目次を作成します。見出しが少ない場合は nil を返します。
+func tableOfContents(doc ast.Node) []tocEntry {
+	var toc []tocEntry
+	count := 0
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		heading, ok := node.(*ast.Heading)
+		if !ok || !entering || len(heading.HeadingID) == 0 {
+			return ast.GoToNext
+		}
+		e := tocEntry{ID: heading.HeadingID, Title: nodeText(heading)}
+		switch {
+		case heading.Level == 2:
+			toc = append(toc, e)
+		case heading.Level == 3 && len(toc) > 0:
+			toc[len(toc)-1].Children = append(toc[len(toc)-1].Children, e)
+		case heading.Level == 3:
+			toc = append(toc, e)
+		default:
+			return ast.SkipChildren
+		}
+		count++
+		return ast.SkipChildren
+	})
+	if count < tocMinHeadings {
+		return nil
+	}
+	return toc
+}
+
+// nodeText はノード配下のテキストを連結して返します。
+func nodeText(node ast.Node) string {
+	var sb strings.Builder
+	ast.WalkFunc(node, func(n ast.Node, entering bool) ast.WalkStatus {
+		if leaf := n.AsLeaf(); entering && leaf != nil {
+			sb.Write(leaf.Literal)
+		}
+		return ast.GoToNext
+	})
+	return sb.String()
+}
+
+// renderHeadingAnchor は ID のある見出しの末尾に、その見出しへのリンクを出力します。閉じタグは標準のレンダラーが出力します。
+func renderHeadingAnchor(w io.Writer, node ast.Node, entering bool) {
+	if heading, ok := node.(*ast.Heading); ok && !entering && len(heading.HeadingID) > 0 {
+		io.WriteString(w, ` <a class="anchor" href="#`+html.EscapeString(heading.HeadingID)+`">#</a>`)
+	}
+}
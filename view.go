@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// pdfViewer は文書中の PDF の添付ファイルへのリンクを、サイトのページに埋め込んで表示する /view/ のページへのリンクにするかどうかです。
+var pdfViewer bool
+
+// fileLink は添付ファイルへのリンクを返します。-pdf-viewer を指定した場合、PDF は /view/ のページへのリンクにします。
+func fileLink(name, root string) string {
+	if pdfViewer && strings.EqualFold(path.Ext(name), ".pdf") {
+		return root + "view/" + name
+	}
+	return name
+}
+
+// handleView は PDF の添付ファイルをサイトのヘッダーとダウンロードのリンク付きのページに埋め込んで表示します。
+func handleView(w http.ResponseWriter, r *http.Request) {
+	if !authorized(w, r) {
+		return
+	}
+	fileName := strings.TrimPrefix(r.URL.Path, "/view/")
+	indexMutex.RLock()
+	file, ok := lookupLinkedFile(r, fileLinkToNameMap, fileName)
+	indexMutex.RUnlock()
+	if !ok || !strings.EqualFold(path.Ext(fileName), ".pdf") || strings.Contains(fileName, "/") {
+		notFound(w, r)
+		return
+	}
+	data := map[string]any{
+		"FileName":  fileName,
+		"Title":     file.displayName,
+		"SiteTitle": siteTitle,
+		"Logo":      siteLogo,
+		"CustomCSS": customCSS != nil,
+		"Msg":       ui,
+	}
+	if err := viewTemplate.Execute(w, data); err != nil {
+		logRequestError(r, "failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce は連続した変更をまとめて 1 回の走査にするための待ち時間です。
+const watchDebounce = 500 * time.Millisecond
+
+// watch は Markdown、画像、ファイルのディレクトリを監視し、変更があったディレクトリを走査し直します。
+func watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	// fsnotify はサブディレクトリを監視しないため、Markdown ディレクトリは全てのディレクトリを追加する
+	if err = addWatchDirs(watcher, mdDir); err != nil {
+		return err
+	}
+	for _, dir := range []string{imgDir, fileDir} {
+		if err := watcher.Add(dir); err != nil {
+			log.Printf("WARNING: failed to watch %s: %v", dir, err)
+		}
+	}
+	rescanMarkdown := debounce(func() {
+		if err := scanMarkdown(); err != nil {
+			log.Printf("failed to rescan markdown directory %s: %v", mdDir, err)
+			return
+		}
+		log.Printf("rescanned markdown directory %s", mdDir)
+	})
+	rescanImages := debounce(scanImages)
+	rescanFiles := debounce(scanFiles)
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				switch dir := filepath.Dir(event.Name); {
+				case dir == filepath.Clean(imgDir):
+					rescanImages()
+				case dir == filepath.Clean(fileDir):
+					rescanFiles()
+				default:
+					if event.Has(fsnotify.Create) {
+						if err := addWatchDirs(watcher, event.Name); err != nil {
+							log.Printf("WARNING: failed to watch %s: %v", event.Name, err)
+						}
+					}
+					rescanMarkdown()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("watcher error: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// addWatchDirs は root 配下の全てのディレクトリ (-follow-symlinks の場合はシンボリックリンクのディレクトリを含む) を監視対象に追加します。
+// root がファイルの場合は何もしません。
+func addWatchDirs(watcher *fsnotify.Watcher, root string) error {
+	return walkFollowingSymlinks(osFS{}, root, func(filePath string, info fs.FileInfo) error {
+		if !info.IsDir() {
+			return nil
+		}
+		return watcher.Add(filePath)
+	})
+}
+
+// debounce は最後に呼び出されてから watchDebounce 経過した時に f を 1 回だけ実行する関数を返します。
+func debounce(f func()) func() {
+	var timer *time.Timer
+	return func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		timer = time.AfterFunc(watchDebounce, f)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build cgo
+
+package main
+
+import (
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// webpSupported は WebP への変換に対応しているかどうかです。変換には cgo (libwebp) が必要です。
+const webpSupported = true
+
+func encodeWebP(w io.Writer, img image.Image) error {
+	return webp.Encode(w, img, &webp.Options{Quality: 80})
+}
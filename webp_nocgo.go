@@ -0,0 +1,16 @@
+//go:build !cgo
+
+package main
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// webpSupported は WebP への変換に対応しているかどうかです。変換には cgo (libwebp) が必要です。
+const webpSupported = false
+
+func encodeWebP(io.Writer, image.Image) error {
+	return errors.New("WebP encoding requires cgo")
+}